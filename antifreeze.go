@@ -1,48 +1,187 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry/cli/plugin"
-
-	"gopkg.in/yaml.v2"
+	"github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/git"
+	"github.com/odlp/antifreeze/source"
 )
 
 func main() {
-	plugin.Start(&AntifreezePlugin{})
+	if IsPluginLaunch(os.Args) {
+		plugin.Start(&AntifreezePlugin{})
+		return
+	}
+
+	runStandalone(os.Args[1:])
+}
+
+// IsPluginLaunch reports whether the cf CLI launched the binary as a
+// plugin, which it does with the port of its RPC server as the first
+// argument. Otherwise the binary is being run on its own, e.g.
+// `antifreeze check-manifest ...`.
+func IsPluginLaunch(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	_, err := strconv.Atoi(args[1])
+	return err == nil
+}
+
+// runStandalone runs a command without the cf CLI, talking to the Cloud
+// Controller directly as the user logged in with `cf login`.
+func runStandalone(args []string) {
+	if len(args) == 0 {
+		fmt.Printf("Usage:\n  %s\n  %s\n", standaloneUsage(checkManifestUsage), standaloneUsage(lintManifestUsage))
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "check-manifest":
+		options, err := ParseArgs(args)
+		fatalIf(err)
+		connection, err := StandaloneConnection(options, os.Getenv)
+		fatalIf(err)
+		checkManifest(connection, options)
+	case "lint-manifest":
+		runLintManifest(args)
+	default:
+		fatalIf(fmt.Errorf("Unknown command '%s', expected check-manifest or lint-manifest", args[0]))
+	}
+}
+
+// StandaloneConnection connects to Cloud Foundry as the user logged in with
+// `cf login`. When replaying a session there's nothing to connect to, so no
+// cf config is needed and the connection is nil.
+func StandaloneConnection(options CheckOptions, getenv func(string) string) (plugin.CliConnection, error) {
+	if options.ReplayPath != "" {
+		return nil, nil
+	}
+
+	config, err := source.LoadCFConfig(getenv)
+	if err != nil {
+		return nil, err
+	}
+	return source.NewStandaloneConnection(config), nil
+}
+
+func standaloneUsage(usage string) string {
+	return "antifreeze" + strings.TrimPrefix(usage, "cf")
 }
 
 type AntifreezePlugin struct{}
 
 func (c *AntifreezePlugin) Run(cliConnection plugin.CliConnection, args []string) {
-	if args[0] != "check-manifest" {
+	switch args[0] {
+	case "check-manifest":
+		runCheckManifest(cliConnection, args)
+	case "lint-manifest":
+		runLintManifest(args)
+	default:
 		os.Exit(0)
 	}
+}
 
-	fmt.Println("Running check-manifest...")
-
-	appName, manifestPath, err := ParseArgs(args)
+func runCheckManifest(cliConnection plugin.CliConnection, args []string) {
+	options, err := ParseArgs(args)
 	fatalIf(err)
+	checkManifest(cliConnection, options)
+}
 
-	manifestEnv, manifestServices, err := ParseManifest(manifestPath, appName)
-	fatalIf(err)
+func checkManifest(cliConnection plugin.CliConnection, options CheckOptions) {
+	if options.GitRef != "" {
+		revision, err := git.Resolve(filepath.Dir(options.ManifestPath), options.GitRef)
+		fatalIf(err)
+		options.Revision = &revision
+	}
+
+	if options.Output == check.OutputText {
+		fmt.Println(runningMessage(options))
+	} else if options.Revision != nil {
+		// Other formats are for tools to read, so the commit goes to stderr
+		// where it still shows in CI logs.
+		fmt.Fprintln(os.Stderr, runningMessage(options))
+	}
+
+	var recorder *source.Recorder
+
+	switch {
+	case options.RecordPath != "":
+		recorder = source.NewRecorder(cliConnection)
+		cliConnection = recorder
+		options.Transport = recorder.Transport()
+	case options.ReplayPath != "":
+		session, err := source.LoadSession(options.ReplayPath)
+		fatalIf(err)
+		replayer := source.NewReplayer(session)
+		cliConnection = replayer
+		options.Transport = replayer
+	}
+
+	findings, err := check.NewChecker(cliConnection, options.Options).Check()
+
+	if recorder != nil {
+		fatalIf(recorder.Save(options.RecordPath))
+	}
 
-	appEnv, appServices, err := GetAppEnvAndServices(cliConnection, appName)
 	fatalIf(err)
 
-	missingEnv := MissingFromManifest(manifestEnv, appEnv)
-	missingServices := MissingFromManifest(manifestServices, appServices)
+	fatalIf(check.WriteFindings(os.Stdout, options.Output, findings))
 
-	if len(missingEnv) == 0 && len(missingServices) == 0 {
-		os.Exit(0)
+	if options.RemediationScriptPath != "" {
+		fatalIf(writeRemediationScript(options.RemediationScriptPath, findings))
 	}
 
-	printMissingValues(appName, manifestPath, missingEnv, missingServices)
-	os.Exit(1)
+	if check.HasErrors(findings) {
+		os.Exit(1)
+	}
+}
 
+func runningMessage(options CheckOptions) string {
+	if options.Revision == nil {
+		return "Running check-manifest..."
+	}
+	return fmt.Sprintf("Running check-manifest against the manifest at %s...", options.Revision)
+}
+
+func writeRemediationScript(path string, findings []check.Finding) error {
+	var script bytes.Buffer
+	if err := check.WriteRemediationScript(&script, findings); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, script.Bytes(), 0755); err != nil {
+		return fmt.Errorf("Unable to write remediation script: %s", err)
+	}
+	return nil
+}
+
+func runLintManifest(args []string) {
+	options, err := ParseLintArgs(args)
+	fatalIf(err)
+
+	if options.Output == check.OutputText {
+		fmt.Println("Running lint-manifest...")
+	}
+
+	findings, err := check.LintManifest(options.ManifestPath)
+	fatalIf(err)
+
+	fatalIf(check.WriteFindings(os.Stdout, options.Output, findings))
+
+	if check.HasErrors(findings) {
+		os.Exit(1)
+	}
 }
 
 func (c *AntifreezePlugin) GetMetadata() plugin.PluginMetadata {
@@ -62,117 +201,173 @@ func (c *AntifreezePlugin) GetMetadata() plugin.PluginMetadata {
 			plugin.Command{
 				Name:     "check-manifest",
 				HelpText: "Check your manifest isn't missing any ENV vars or services currently in an app",
+				UsageDetails: plugin.Usage{
+					Usage: checkManifestUsage,
+				},
+			},
+			plugin.Command{
+				Name:     "lint-manifest",
+				HelpText: "Check your manifest for mistakes without connecting to Cloud Foundry",
+				UsageDetails: plugin.Usage{
+					Usage: lintManifestUsage,
+				},
 			},
 		},
 	}
 }
 
-func ParseArgs(args []string) (string, string, error) {
-	flags := flag.NewFlagSet("check-manifest", flag.ContinueOnError)
-	manifestPath := flags.String("f", "", "path to an application manifest")
-	err := flags.Parse(args[2:])
+const (
+	checkManifestUsage = "cf check-manifest [APP_NAME] -f manifest.yml [-f overrides.yml] [-o ops.yml] [--output text|github|gitlab|ops-file] [--since 30d] [--ignore-metadata-prefix PREFIX] [--bindings bindings.yml] [--pins pins.yml] [--policy policy.yml] [--concurrency 4] [--timeout 30s] [--retries 2] [--record session.json|--replay session.json] [--remediation-script fix.sh] [--remediation-values] [--git-ref REF] [--git-blame]"
+	lintManifestUsage  = "cf lint-manifest -f manifest.yml [--output text|github|gitlab]"
+)
 
-	if err != nil {
-		return "", "", err
-	}
+// CheckOptions are the check-manifest flags: the Checker's options, and
+// how to report findings and record or replay the session.
+type CheckOptions struct {
+	check.Options
 
-	if *manifestPath == "" {
-		return "", "", fmt.Errorf("Missing manifest argument")
-	}
+	Output string
 
-	appName := args[1]
-	return appName, *manifestPath, nil
-}
+	// RecordPath is where to save a session of every call to Cloud Foundry,
+	// with credentials redacted, and ReplayPath is a saved session to check
+	// against instead of Cloud Foundry.
+	RecordPath string
+	ReplayPath string
 
-func GetAppEnvAndServices(cliConnection plugin.CliConnection, appName string) (appEnv []string, appServices []string, err error) {
-	app, _ := cliConnection.GetApp(appName)
+	// GitRef is the git revision to read the manifest at, resolved into
+	// Options.Revision.
+	GitRef string
 
-	for k := range app.EnvironmentVars {
-		appEnv = append(appEnv, k)
-	}
-
-	for _, s := range app.Services {
-		appServices = append(appServices, s.Name)
-	}
-
-	return appEnv, appServices, err
+	// RemediationScriptPath is where to write the cf commands which revert
+	// each app to its manifest.
+	RemediationScriptPath string
 }
 
-type YManifest struct {
-	Applications []YApplication `yaml:"applications"`
+type LintOptions struct {
+	ManifestPath string
+	Output       string
 }
 
-type YApplication struct {
-	Name     string                 `yaml:"name"`
-	Env      map[string]interface{} `yaml:"env"`
-	Services []string               `yaml:"services"`
-}
+func ParseArgs(args []string) (CheckOptions, error) {
+	flags := flag.NewFlagSet("check-manifest", flag.ContinueOnError)
+	var manifestPaths stringList
+	flags.Var(&manifestPaths, "f", "path to an application manifest (repeatable, deep merged in order)")
+	var opsPaths stringList
+	flags.Var(&opsPaths, "o", "path to a go-patch ops file to apply to the manifest (repeatable)")
+	output := flags.String("output", check.OutputText, "report format: text, github, gitlab or ops-file")
+	since := flags.String("since", "", "only attribute findings to changes after this date, timestamp or duration")
+	var ignoreMetadataPrefixes stringList
+	flags.Var(&ignoreMetadataPrefixes, "ignore-metadata-prefix", "label and annotation prefix to ignore (repeatable)")
+	bindingsPath := flags.String("bindings", "", "path to a file declaring required service credential keys")
+	pinsPath := flags.String("pins", "", "path to a file pinning secret ENV vars to the digest of their value")
+	policyPath := flags.String("policy", "", "path to a file of policy rules")
+	concurrency := flags.Int("concurrency", defaultConcurrency, "number of apps to check at once, though calls to the cf CLI are still made one at a time")
+	timeout := flags.Duration("timeout", defaultTimeout, "time limit for each request")
+	retries := flags.Int("retries", defaultRetries, "number of times to retry requests which fail with a transient error")
+	recordPath := flags.String("record", "", "path to save a session of every call to Cloud Foundry, with credentials redacted")
+	replayPath := flags.String("replay", "", "path to a saved session to check instead of Cloud Foundry")
+	gitRef := flags.String("git-ref", "", "git revision to read the manifest at, such as a release tag")
+	gitBlame := flags.Bool("git-blame", false, "note the commit which last changed each conflicting manifest key")
+	remediationScriptPath := flags.String("remediation-script", "", "path to write a shell script reverting each app to its manifest")
+	remediationValues := flags.Bool("remediation-values", false, "suggest the app's ENV values in manifest snippets rather than ((variables)), except values which look like secrets")
+
+	// The app name is optional: without one, every app in the manifest is
+	// checked.
+	var appName string
+	flagArgs := args[1:]
+	if len(flagArgs) > 0 && !strings.HasPrefix(flagArgs[0], "-") {
+		appName, flagArgs = flagArgs[0], flagArgs[1:]
+	}
 
-func ParseManifest(manifestPath, appName string) (manifestEnv []string, manifestServices []string, err error) {
-	document, err := loadYAML(manifestPath)
+	err := flags.Parse(flagArgs)
 
 	if err != nil {
-		return manifestEnv, manifestServices, err
+		return CheckOptions{}, err
 	}
 
-	app, err := findApp(appName, document.Applications)
-
-	if err != nil {
-		return manifestEnv, manifestServices, err
+	if len(manifestPaths) == 0 || manifestPaths[0] == "" {
+		return CheckOptions{}, fmt.Errorf("Missing manifest argument")
 	}
 
-	for k := range app.Env {
-		manifestEnv = append(manifestEnv, k)
+	if !stringInSlice(*output, check.OutputFormats) {
+		return CheckOptions{}, fmt.Errorf("Unknown output format '%s'", *output)
 	}
 
-	return manifestEnv, app.Services, nil
-}
+	if *concurrency < 1 {
+		return CheckOptions{}, fmt.Errorf("Invalid --concurrency %d, expected at least 1", *concurrency)
+	}
 
-func MissingFromManifest(manifestList, appList []string) (missing []string) {
-	for _, appValue := range appList {
-		if !stringInSlice(appValue, manifestList) {
-			missing = append(missing, appValue)
-		}
+	if *recordPath != "" && *replayPath != "" {
+		return CheckOptions{}, fmt.Errorf("Only one of --record and --replay can be used")
 	}
-	return missing
-}
 
-func loadYAML(manifestPath string) (manifest YManifest, err error) {
-	b, err := ioutil.ReadFile(manifestPath)
+	options := CheckOptions{
+		Options: check.Options{
+			AppName:                appName,
+			ManifestPath:           manifestPaths[0],
+			MergePaths:             manifestPaths[1:],
+			OpsPaths:               opsPaths,
+			IgnoreMetadataPrefixes: ignoreMetadataPrefixes,
+			BindingsPath:           *bindingsPath,
+			PinsPath:               *pinsPath,
+			PolicyPath:             *policyPath,
+			Concurrency:            *concurrency,
+			Timeout:                *timeout,
+			Retries:                *retries,
+			Backoff:                defaultBackoff,
+			Blame:                  *gitBlame,
+			RemediationValues:      *remediationValues,
+		},
+		Output:     *output,
+		RecordPath: *recordPath,
+		ReplayPath: *replayPath,
 
-	if err != nil {
-		return YManifest{}, fmt.Errorf("Unable to read manifest file: %s", manifestPath)
+		GitRef:                *gitRef,
+		RemediationScriptPath: *remediationScriptPath,
 	}
 
-	var document YManifest
-	err = yaml.Unmarshal(b, &document)
-
-	if err != nil {
-		return YManifest{}, fmt.Errorf("Unable to parse manifest YAML")
+	if *since != "" {
+		options.Since, err = check.ParseSince(*since, time.Now())
+		if err != nil {
+			return CheckOptions{}, err
+		}
 	}
 
-	return document, nil
+	return options, nil
 }
 
-func findApp(appName string, apps []YApplication) (app YApplication, err error) {
-	if len(apps) == 0 {
-		return YApplication{}, fmt.Errorf("No application found in manifest")
-	}
+// stringList collects the values of a repeatable flag.
+type stringList []string
 
-	appIndex := notFoundIndex
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
 
-	for i := range apps {
-		if apps[i].Name == appName {
-			appIndex = i
-			break
-		}
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func ParseLintArgs(args []string) (LintOptions, error) {
+	flags := flag.NewFlagSet("lint-manifest", flag.ContinueOnError)
+	manifestPath := flags.String("f", "", "path to an application manifest")
+	output := flags.String("output", check.OutputText, "report format: text, github or gitlab")
+	err := flags.Parse(args[1:])
+
+	if err != nil {
+		return LintOptions{}, err
 	}
 
-	if appIndex == notFoundIndex {
-		return YApplication{}, fmt.Errorf("Application '%s' not found in manifest", appName)
+	if *manifestPath == "" {
+		return LintOptions{}, fmt.Errorf("Missing manifest argument")
+	}
+
+	// Lint findings have no fix to write as ops.
+	if !stringInSlice(*output, check.OutputFormats) || *output == check.OutputOpsFile {
+		return LintOptions{}, fmt.Errorf("Unknown output format '%s'", *output)
 	}
 
-	return apps[appIndex], nil
+	return LintOptions{ManifestPath: *manifestPath, Output: *output}, nil
 }
 
 func stringInSlice(a string, list []string) bool {
@@ -191,22 +386,9 @@ func fatalIf(err error) {
 	}
 }
 
-func printMissingValues(appName string, manifestPath string, missingEnv []string, missingServices []string) {
-	if len(missingEnv) > 0 {
-		fmt.Printf("\nApp '%s' has unexpected ENV vars (missing from manifest %s):\n", appName, manifestPath)
-		printListAsBullets(missingEnv)
-	}
-
-	if len(missingServices) > 0 {
-		fmt.Printf("\nApp '%s' has unexpected services (missing from manifest %s):\n", appName, manifestPath)
-		printListAsBullets(missingServices)
-	}
-}
-
-func printListAsBullets(list []string) {
-	for _, v := range list {
-		fmt.Printf("- %s\n", v)
-	}
-}
-
-const notFoundIndex = -1
+const (
+	defaultConcurrency = 4
+	defaultTimeout     = 30 * time.Second
+	defaultRetries     = 2
+	defaultBackoff     = 500 * time.Millisecond
+)
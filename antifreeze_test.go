@@ -2,10 +2,10 @@ package main_test
 
 import (
 	"testing"
+	"time"
 
-	"github.com/cloudfoundry/cli/plugin/models"
-	"github.com/cloudfoundry/cli/plugin/pluginfakes"
 	. "github.com/odlp/antifreeze"
+	"github.com/odlp/antifreeze/check"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -17,7 +17,7 @@ func TestAntifreeze(t *testing.T) {
 
 var _ = Describe("Flag Parsing", func() {
 	It("parses args", func() {
-		appName, manifestPath, err := ParseArgs(
+		options, err := ParseArgs(
 			[]string{
 				"validate-manifest-ok",
 				"app-name",
@@ -25,149 +25,214 @@ var _ = Describe("Flag Parsing", func() {
 			},
 		)
 		Expect(err).ToNot(HaveOccurred())
-		Expect(appName).To(Equal("app-name"))
-		Expect(manifestPath).To(Equal("manifest-path"))
+		Expect(options.AppName).To(Equal("app-name"))
+		Expect(options.ManifestPath).To(Equal("manifest-path"))
+		Expect(options.Output).To(Equal(check.OutputText))
 	})
 
-	It("requires a manifest", func() {
-		_, _, err := ParseArgs(
+	It("parses the output format", func() {
+		options, err := ParseArgs(
 			[]string{
 				"validate-manifest-ok",
 				"app-name",
+				"-f", "manifest-path",
+				"--output", "github",
 			},
 		)
-		Expect(err).To(MatchError("Missing manifest argument"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.Output).To(Equal(check.OutputGitHub))
 	})
-})
 
-var _ = Describe("Parsing Manifest", func() {
-	It("parses the ENV keys", func() {
-		envKeys, _, err := ParseManifest("./fixtures/manifest.yml", "app-name")
+	It("parses the ops file output format", func() {
+		options, err := ParseArgs([]string{"check-manifest", "app-name", "-f", "manifest-path", "--output", "ops-file"})
 		Expect(err).ToNot(HaveOccurred())
-		Expect(envKeys).To(HaveLen(2))
-		Expect(envKeys).To(ContainElement("ENV_VAR_1"))
-		Expect(envKeys).To(ContainElement("ENV_VAR_2"))
+		Expect(options.Output).To(Equal(check.OutputOpsFile))
 	})
 
-	It("parses the service names", func() {
-		_, serviceNames, err := ParseManifest("./fixtures/manifest.yml", "app-name")
+	It("parses repeated manifests to merge in order", func() {
+		options, err := ParseArgs([]string{"check-manifest", "app-name", "-f", "base.yml", "-f", "prod.yml", "-f", "scale.yml"})
 		Expect(err).ToNot(HaveOccurred())
-		Expect(serviceNames).To(HaveLen(2))
-		Expect(serviceNames).To(ContainElement("service-1"))
-		Expect(serviceNames).To(ContainElement("service-2"))
+		Expect(options.ManifestPath).To(Equal("base.yml"))
+		Expect(options.MergePaths).To(Equal([]string{"prod.yml", "scale.yml"}))
 	})
 
-	Context("multi-app manifest", func() {
-		It("returns the values for the correct app", func() {
-			envKeys, serviceNames, err := ParseManifest("./fixtures/multi-manifest.yml", "app-2")
-			Expect(err).ToNot(HaveOccurred())
+	It("parses repeated ops files in order", func() {
+		options, err := ParseArgs([]string{"check-manifest", "app-name", "-f", "manifest-path", "-o", "ops/prod.yml", "-o", "ops/scale.yml"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.OpsPaths).To(Equal([]string{"ops/prod.yml", "ops/scale.yml"}))
+	})
 
-			Expect(serviceNames).To(HaveLen(2))
-			Expect(serviceNames).To(ContainElement("service-3"))
-			Expect(serviceNames).To(ContainElement("service-4"))
+	It("parses repeated metadata prefixes to ignore", func() {
+		options, err := ParseArgs(
+			[]string{
+				"validate-manifest-ok",
+				"app-name",
+				"-f", "manifest-path",
+				"--ignore-metadata-prefix", "example.com",
+				"--ignore-metadata-prefix", "autoscaler.io",
+			},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.IgnoreMetadataPrefixes).To(Equal([]string{"example.com", "autoscaler.io"}))
+	})
 
-			Expect(envKeys).To(HaveLen(2))
-			Expect(envKeys).To(ContainElement("ENV_VAR_3"))
-			Expect(envKeys).To(ContainElement("ENV_VAR_4"))
-		})
+	It("accepts a bindings file", func() {
+		options, err := ParseArgs(
+			[]string{
+				"validate-manifest-ok",
+				"app-name",
+				"-f", "manifest-path",
+				"--bindings", "bindings.yml",
+			},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.BindingsPath).To(Equal("bindings.yml"))
 	})
 
-	Context("app doesn't exist in the manifest", func() {
-		It("returns an error", func() {
-			_, _, err := ParseManifest("./fixtures/multi-manifest.yml", "app-666")
-			Expect(err).To(MatchError("Application 'app-666' not found in manifest"))
-		})
+	It("accepts a pins file", func() {
+		options, err := ParseArgs(
+			[]string{
+				"validate-manifest-ok",
+				"app-name",
+				"-f", "manifest-path",
+				"--pins", "pins.yml",
+			},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.PinsPath).To(Equal("pins.yml"))
 	})
 
-	Context("invalid manifest path", func() {
-		It("returns an error", func() {
-			_, _, err := ParseManifest("./pure-fiction", "fictional-app")
-			Expect(err).To(MatchError("Unable to read manifest file: ./pure-fiction"))
-		})
+	It("checks every app when no app is named", func() {
+		options, err := ParseArgs(
+			[]string{
+				"check-manifest",
+				"-f", "manifest-path",
+				"--concurrency", "8",
+				"--timeout", "10s",
+				"--retries", "3",
+			},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.AppName).To(BeEmpty())
+		Expect(options.ManifestPath).To(Equal("manifest-path"))
+		Expect(options.Concurrency).To(Equal(8))
+		Expect(options.Timeout).To(Equal(10 * time.Second))
+		Expect(options.Retries).To(Equal(3))
 	})
 
-	Context("invalid manifest", func() {
-		It("returns an error", func() {
-			_, _, err := ParseManifest("./fixtures/invalid-manifest.json", "app-name")
-			Expect(err).To(MatchError("No application found in manifest"))
-		})
+	It("rejects a concurrency below 1", func() {
+		_, err := ParseArgs([]string{"check-manifest", "-f", "manifest-path", "--concurrency", "0"})
+		Expect(err).To(MatchError("Invalid --concurrency 0, expected at least 1"))
+	})
+
+	It("accepts a policy file", func() {
+		options, err := ParseArgs(
+			[]string{
+				"validate-manifest-ok",
+				"app-name",
+				"-f", "manifest-path",
+				"--policy", "policy.yml",
+			},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.PolicyPath).To(Equal("policy.yml"))
 	})
-})
 
-var _ = Describe("Get App Env And Services", func() {
-	var cliConnection *pluginfakes.FakeCliConnection
-	var fakeApp plugin_models.GetAppModel
+	It("accepts a session to record or replay, but not both", func() {
+		options, err := ParseArgs([]string{"check-manifest", "app-name", "-f", "manifest-path", "--record", "session.json"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.RecordPath).To(Equal("session.json"))
 
-	BeforeEach(func() {
-		cliConnection = &pluginfakes.FakeCliConnection{}
-		fakeAppEnv := map[string]interface{}{"ENV_VAR_1": "foo", "ENV_VAR_2": "bar"}
-		service1 := plugin_models.GetApp_ServiceSummary{
-			Name: "service-1",
-		}
-		service2 := plugin_models.GetApp_ServiceSummary{
-			Name: "service-2",
-		}
-		fakeApp = plugin_models.GetAppModel{
-			EnvironmentVars: fakeAppEnv,
-			Services:        []plugin_models.GetApp_ServiceSummary{service1, service2},
-		}
+		options, err = ParseArgs([]string{"check-manifest", "app-name", "-f", "manifest-path", "--replay", "session.json"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.ReplayPath).To(Equal("session.json"))
 
-		cliConnection.GetAppStub = func(arg1 string) (plugin_models.GetAppModel, error) {
-			Expect(arg1).To(Equal("app-name"))
-			return fakeApp, nil
-		}
+		_, err = ParseArgs([]string{"check-manifest", "-f", "manifest-path", "--record", "a.json", "--replay", "b.json"})
+		Expect(err).To(MatchError("Only one of --record and --replay can be used"))
 	})
 
-	It("returns the ENV keys from the application", func() {
-		appEnv, _, err := GetAppEnvAndServices(cliConnection, "app-name")
+	It("accepts a path to write a remediation script to", func() {
+		options, err := ParseArgs([]string{"check-manifest", "app-name", "-f", "manifest-path", "--remediation-script", "fix.sh"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.RemediationScriptPath).To(Equal("fix.sh"))
+	})
 
+	It("accepts a git revision to read the manifest at, and whether to blame", func() {
+		options, err := ParseArgs([]string{"check-manifest", "app-name", "-f", "manifest-path", "--git-ref", "v1.4.2", "--git-blame"})
 		Expect(err).ToNot(HaveOccurred())
-		Expect(appEnv).To(HaveLen(2))
-		Expect(appEnv).To(ContainElement("ENV_VAR_1"))
-		Expect(appEnv).To(ContainElement("ENV_VAR_2"))
+		Expect(options.GitRef).To(Equal("v1.4.2"))
+		Expect(options.Blame).To(BeTrue())
 	})
 
-	It("returns the service keys from the application", func() {
-		_, appServices, err := GetAppEnvAndServices(cliConnection, "app-name")
+	It("rejects unknown output formats", func() {
+		_, err := ParseArgs(
+			[]string{
+				"validate-manifest-ok",
+				"app-name",
+				"-f", "manifest-path",
+				"--output", "xml",
+			},
+		)
+		Expect(err).To(MatchError("Unknown output format 'xml'"))
+	})
+
+	It("requires a manifest", func() {
+		_, err := ParseArgs(
+			[]string{
+				"validate-manifest-ok",
+				"app-name",
+			},
+		)
+		Expect(err).To(MatchError("Missing manifest argument"))
+	})
 
+	It("parses lint args", func() {
+		options, err := ParseLintArgs(
+			[]string{
+				"lint-manifest",
+				"-f", "manifest-path",
+				"--output", "gitlab",
+			},
+		)
 		Expect(err).ToNot(HaveOccurred())
-		Expect(appServices).To(HaveLen(2))
-		Expect(appServices).To(ContainElement("service-1"))
-		Expect(appServices).To(ContainElement("service-2"))
+		Expect(options.ManifestPath).To(Equal("manifest-path"))
+		Expect(options.Output).To(Equal(check.OutputGitLab))
 	})
-})
 
-var _ = Describe("Missing From Manifest", func() {
-	Context("no differences", func() {
-		It("returns an empty slice", func() {
-			manifestEnv := []string{"ENV_VAR_1"}
-			appEnv := []string{"ENV_VAR_1"}
+	It("doesn't write lint findings as ops", func() {
+		_, err := ParseLintArgs([]string{"lint-manifest", "-f", "manifest-path", "--output", "ops-file"})
+		Expect(err).To(MatchError("Unknown output format 'ops-file'"))
+	})
 
-			difference := MissingFromManifest(manifestEnv, appEnv)
-			Expect(difference).To(HaveLen(0))
-		})
+	It("requires a manifest to lint", func() {
+		_, err := ParseLintArgs([]string{"lint-manifest"})
+		Expect(err).To(MatchError("Missing manifest argument"))
 	})
+})
 
-	Context("manifest has additional values", func() {
-		It("returns an empty slice", func() {
-			manifestEnv := []string{"ENV_VAR_1", "ENV_VAR_2"}
-			appEnv := []string{"ENV_VAR_1"}
+var _ = Describe("StandaloneConnection", func() {
+	noConfig := func(name string) string {
+		if name == "HOME" {
+			return "/nonexistent"
+		}
+		return ""
+	}
+
+	It("needs a cf config to check Cloud Foundry", func() {
+		options, err := ParseArgs([]string{"check-manifest", "app-name", "-f", "manifest-path"})
+		Expect(err).ToNot(HaveOccurred())
 
-			difference := MissingFromManifest(manifestEnv, appEnv)
-			Expect(difference).To(HaveLen(0))
-		})
+		_, err = StandaloneConnection(options, noConfig)
+		Expect(err).To(MatchError("No API endpoint set, run `cf login` or set CF_API"))
 	})
 
-	Context("app has additional values", func() {
-		It("returns the offending values", func() {
-			manifestEnv := []string{"ENV_VAR_1"}
-			appEnv := []string{"ENV_VAR_1", "ENV_SNOW", "ENV_FLAKE"}
+	It("replays a session without a cf config", func() {
+		options, err := ParseArgs([]string{"check-manifest", "app-name", "-f", "manifest-path", "--replay", "session.json"})
+		Expect(err).ToNot(HaveOccurred())
 
-			difference := MissingFromManifest(manifestEnv, appEnv)
-			Expect(difference).To(HaveLen(2))
-			Expect(difference).To(ContainElement("ENV_SNOW"))
-			Expect(difference).To(ContainElement("ENV_FLAKE"))
-		})
+		connection, err := StandaloneConnection(options, noConfig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(connection).To(BeNil())
 	})
 })
 
@@ -179,6 +244,14 @@ var _ = Describe("GetMetadata", func() {
 		Expect(metadata.Name).To(Equal("antifreeze"))
 		Expect(metadata.Version).ToNot(BeNil())
 		Expect(metadata.MinCliVersion).ToNot(BeNil())
-		Expect(metadata.Commands).To(HaveLen(1))
+		Expect(metadata.Commands).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("IsPluginLaunch", func() {
+	It("recognises the cf CLI's RPC port as the first argument", func() {
+		Expect(IsPluginLaunch([]string{"antifreeze", "52341", "check-manifest"})).To(BeTrue())
+		Expect(IsPluginLaunch([]string{"antifreeze", "check-manifest", "my-app"})).To(BeFalse())
+		Expect(IsPluginLaunch([]string{"antifreeze"})).To(BeFalse())
 	})
 })
@@ -0,0 +1,240 @@
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/internal/cc"
+)
+
+// AuditEvent is a Cloud Controller audit event recording a change to an app.
+type AuditEvent struct {
+	Type      string
+	Actor     string
+	CreatedAt time.Time
+
+	// EnvChanged is set when an app update included its ENV vars.
+	EnvChanged bool
+
+	// ServiceInstanceGuid is the instance bound by a service binding event.
+	ServiceInstanceGuid string
+}
+
+const (
+	AuditAppUpdate            = "audit.app.update"
+	AuditServiceBindingCreate = "audit.service_binding.create"
+)
+
+// GetAuditEvents looks up the app update and service binding events for an
+// app, newest first. A zero since looks up every event the Cloud Controller
+// still holds.
+func GetAuditEvents(client *cc.Client, app plugin_models.GetAppModel, since time.Time) ([]AuditEvent, error) {
+	events, err := GetSpaceAuditEvents(client, app.SpaceGuid, []string{app.Guid}, since)
+	if err != nil {
+		return nil, err
+	}
+	return events[app.Guid], nil
+}
+
+// auditTargetsPerRequest limits how many apps' events are looked up in one
+// request, keeping the URL to a reasonable length.
+const auditTargetsPerRequest = 50
+
+// GetSpaceAuditEvents looks up the audit events of several apps in a space
+// at once, rather than a few requests for each app, keyed by app guid.
+func GetSpaceAuditEvents(client *cc.Client, spaceGuid string, appGuids []string, since time.Time) (map[string][]AuditEvent, error) {
+	events := map[string][]AuditEvent{}
+
+	for start := 0; start < len(appGuids); start += auditTargetsPerRequest {
+		end := start + auditTargetsPerRequest
+		if end > len(appGuids) {
+			end = len(appGuids)
+		}
+
+		query := url.Values{}
+		query.Set("types", AuditAppUpdate)
+		query.Set("target_guids", strings.Join(appGuids[start:end], ","))
+
+		updates, err := fetchAuditEvents(client, query, since)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range updates {
+			events[e.Target.GUID] = append(events[e.Target.GUID], AuditEvent{
+				Type:       e.Type,
+				Actor:      e.Actor.Name,
+				CreatedAt:  e.CreatedAt,
+				EnvChanged: e.Data.Request.changesEnv(),
+			})
+		}
+	}
+
+	// Binding events target the binding rather than the app, so they're
+	// looked up across the space and matched on the bound app.
+	query := url.Values{}
+	query.Set("types", AuditServiceBindingCreate)
+	query.Set("space_guids", spaceGuid)
+
+	bindings, err := fetchAuditEvents(client, query, since)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range bindings {
+		appGuid, instanceGuid := e.Data.Request.binding()
+		if !stringInSlice(appGuid, appGuids) {
+			continue
+		}
+
+		events[appGuid] = append(events[appGuid], AuditEvent{
+			Type:                e.Type,
+			Actor:               e.Actor.Name,
+			CreatedAt:           e.CreatedAt,
+			ServiceInstanceGuid: instanceGuid,
+		})
+	}
+
+	for appGuid := range events {
+		appEvents := events[appGuid]
+		sort.SliceStable(appEvents, func(i, j int) bool {
+			return appEvents[i].CreatedAt.After(appEvents[j].CreatedAt)
+		})
+	}
+
+	return events, nil
+}
+
+// AnnotateFindings records who made the most recent change matching each
+// finding, and when: ENV findings match app updates which set ENV vars and
+// service findings match the binding of that service.
+func AnnotateFindings(findings []Finding, events []AuditEvent, app plugin_models.GetAppModel) []Finding {
+	instanceGuids := map[string]string{}
+	for _, s := range app.Services {
+		instanceGuids[s.Name] = s.Guid
+	}
+
+	for i := range findings {
+		for _, e := range events {
+			if !eventMatches(e, findings[i], instanceGuids) {
+				continue
+			}
+
+			findings[i].Actor = e.Actor
+			findings[i].ChangedAt = e.CreatedAt
+			break
+		}
+	}
+
+	return findings
+}
+
+func eventMatches(e AuditEvent, f Finding, instanceGuids map[string]string) bool {
+	switch f.Rule {
+	case RuleUnexpectedEnv, RuleChangedEnv, RuleTypoEnv:
+		return e.Type == AuditAppUpdate && e.EnvChanged
+	case RuleUnexpectedService, RuleTypoService:
+		return e.Type == AuditServiceBindingCreate && e.ServiceInstanceGuid == instanceGuids[f.Key]
+	}
+	return false
+}
+
+// ParseSince accepts an RFC 3339 timestamp, a date, or a duration before
+// now such as `72h` or `30d`.
+func ParseSince(since string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.Parse("2006-01-02", since); err == nil {
+		return t, nil
+	}
+
+	if strings.HasSuffix(since, "d") {
+		var days int
+		if _, err := fmt.Sscanf(since, "%dd", &days); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(since); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid --since value '%s', expected a date, timestamp or duration like 72h or 30d", since)
+}
+
+// auditEvent is an audit event with the parts of its data antifreeze needs
+// decoded.
+type auditEvent struct {
+	cc.AuditEvent
+	Data struct {
+		Request auditEventRequest `json:"request"`
+	}
+}
+
+// auditEventRequest holds the parts of a recorded request antifreeze needs.
+// Events raised through the v2 API and the v3 API record them differently.
+type auditEventRequest struct {
+	EnvironmentJSON      interface{} `json:"environment_json"`
+	EnvironmentVariables interface{} `json:"environment_variables"`
+
+	AppGuid             string `json:"app_guid"`
+	ServiceInstanceGuid string `json:"service_instance_guid"`
+
+	Relationships struct {
+		App struct {
+			Data struct {
+				Guid string `json:"guid"`
+			} `json:"data"`
+		} `json:"app"`
+		ServiceInstance struct {
+			Data struct {
+				Guid string `json:"guid"`
+			} `json:"data"`
+		} `json:"service_instance"`
+	} `json:"relationships"`
+}
+
+func (r auditEventRequest) changesEnv() bool {
+	return r.EnvironmentJSON != nil || r.EnvironmentVariables != nil
+}
+
+func (r auditEventRequest) binding() (appGuid, instanceGuid string) {
+	if r.AppGuid != "" {
+		return r.AppGuid, r.ServiceInstanceGuid
+	}
+	return r.Relationships.App.Data.Guid, r.Relationships.ServiceInstance.Data.Guid
+}
+
+func fetchAuditEvents(client *cc.Client, query url.Values, since time.Time) ([]auditEvent, error) {
+	if !since.IsZero() {
+		query.Set("created_ats[gt]", since.UTC().Format(time.RFC3339))
+	}
+
+	resources, err := client.GetAuditEvents(query)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get audit events: %s", err)
+	}
+
+	var events []auditEvent
+	for _, resource := range resources {
+		e := auditEvent{AuditEvent: resource}
+		if len(resource.Data) > 0 {
+			if err := json.Unmarshal(resource.Data, &e.Data); err != nil {
+				return nil, fmt.Errorf("Unable to parse audit event %s: %s", resource.GUID, err)
+			}
+		}
+
+		if since.IsZero() || e.CreatedAt.After(since) {
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,166 @@
+package check_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/internal/cc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Audit Events", func() {
+	var client *cc.Client
+	var server *httptest.Server
+	var requests []string
+	var app plugin_models.GetAppModel
+	var responses map[string]string
+
+	BeforeEach(func() {
+		app = plugin_models.GetAppModel{
+			Guid:      "app-guid",
+			SpaceGuid: "space-guid",
+			Services: []plugin_models.GetApp_ServiceSummary{
+				{Guid: "instance-guid", Name: "surprise-service"},
+			},
+		}
+
+		responses = map[string]string{
+			"/v3/audit_events?order_by=-created_at&per_page=100&target_guids=app-guid&types=audit.app.update": `{
+				"pagination": {"next": {"href": "SERVER/v3/audit_events?page=2&target_guids=app-guid"}},
+				"resources": [
+					{"type": "audit.app.update", "created_at": "2017-07-20T10:00:00Z", "actor": {"name": "scaler"}, "target": {"guid": "app-guid"}, "data": {"request": {"instances": 2}}}
+				]
+			}`,
+			"/v3/audit_events?page=2&target_guids=app-guid": `{
+				"resources": [
+					{"type": "audit.app.update", "created_at": "2017-07-18T10:00:00Z", "actor": {"name": "jane"}, "target": {"guid": "app-guid"}, "data": {"request": {"environment_json": "PRIVATE DATA HIDDEN"}}}
+				]
+			}`,
+			"/v3/audit_events?order_by=-created_at&per_page=100&space_guids=space-guid&types=audit.service_binding.create": `{
+				"resources": [
+					{"type": "audit.service_binding.create", "created_at": "2017-07-21T10:00:00Z", "actor": {"name": "joe"}, "data": {"request": {"relationships": {"app": {"data": {"guid": "other-app-guid"}}, "service_instance": {"data": {"guid": "instance-guid"}}}}}},
+					{"type": "audit.service_binding.create", "created_at": "2017-07-19T10:00:00Z", "actor": {"name": "sam"}, "data": {"request": {"app_guid": "app-guid", "service_instance_guid": "instance-guid"}}}
+				]
+			}`,
+		}
+
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			requests = append(requests, r.URL.RequestURI())
+			response, ok := responses[r.URL.RequestURI()]
+			Expect(ok).To(BeTrue(), "unexpected request "+r.URL.RequestURI())
+			fmt.Fprint(w, strings.Replace(response, "SERVER", server.URL, -1))
+		}))
+
+		cliConnection := &pluginfakes.FakeCliConnection{}
+		cliConnection.ApiEndpointReturns(server.URL, nil)
+		cliConnection.AccessTokenReturns("bearer token", nil)
+		client = cc.NewClient(cliConnection)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("fetches the app's events newest first", func() {
+		events, err := GetAuditEvents(client, app, time.Time{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(events).To(Equal([]AuditEvent{
+			{Type: AuditAppUpdate, Actor: "scaler", CreatedAt: time.Date(2017, 7, 20, 10, 0, 0, 0, time.UTC)},
+			{Type: AuditServiceBindingCreate, Actor: "sam", CreatedAt: time.Date(2017, 7, 19, 10, 0, 0, 0, time.UTC), ServiceInstanceGuid: "instance-guid"},
+			{Type: AuditAppUpdate, Actor: "jane", CreatedAt: time.Date(2017, 7, 18, 10, 0, 0, 0, time.UTC), EnvChanged: true},
+		}))
+	})
+
+	It("fetches the events of several apps at once", func() {
+		responses = map[string]string{
+			"/v3/audit_events?order_by=-created_at&per_page=100&target_guids=app-guid%2Cother-app-guid&types=audit.app.update": `{
+				"resources": [
+					{"type": "audit.app.update", "created_at": "2017-07-20T10:00:00Z", "actor": {"name": "scaler"}, "target": {"guid": "other-app-guid"}, "data": {"request": {"instances": 2}}},
+					{"type": "audit.app.update", "created_at": "2017-07-18T10:00:00Z", "actor": {"name": "jane"}, "target": {"guid": "app-guid"}, "data": {"request": {"environment_json": "PRIVATE DATA HIDDEN"}}}
+				]
+			}`,
+			"/v3/audit_events?order_by=-created_at&per_page=100&space_guids=space-guid&types=audit.service_binding.create": responses["/v3/audit_events?order_by=-created_at&per_page=100&space_guids=space-guid&types=audit.service_binding.create"],
+		}
+
+		events, err := GetSpaceAuditEvents(client, "space-guid", []string{"app-guid", "other-app-guid"}, time.Time{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests).To(HaveLen(2))
+
+		Expect(events["app-guid"]).To(Equal([]AuditEvent{
+			{Type: AuditServiceBindingCreate, Actor: "sam", CreatedAt: time.Date(2017, 7, 19, 10, 0, 0, 0, time.UTC), ServiceInstanceGuid: "instance-guid"},
+			{Type: AuditAppUpdate, Actor: "jane", CreatedAt: time.Date(2017, 7, 18, 10, 0, 0, 0, time.UTC), EnvChanged: true},
+		}))
+		Expect(events["other-app-guid"]).To(Equal([]AuditEvent{
+			{Type: AuditServiceBindingCreate, Actor: "joe", CreatedAt: time.Date(2017, 7, 21, 10, 0, 0, 0, time.UTC), ServiceInstanceGuid: "instance-guid"},
+			{Type: AuditAppUpdate, Actor: "scaler", CreatedAt: time.Date(2017, 7, 20, 10, 0, 0, 0, time.UTC)},
+		}))
+	})
+
+	It("limits events to those after --since", func() {
+		since := time.Date(2017, 7, 19, 0, 0, 0, 0, time.UTC)
+		responses["/v3/audit_events?created_ats%5Bgt%5D=2017-07-19T00%3A00%3A00Z&order_by=-created_at&per_page=100&target_guids=app-guid&types=audit.app.update"] = responses["/v3/audit_events?order_by=-created_at&per_page=100&target_guids=app-guid&types=audit.app.update"]
+		responses["/v3/audit_events?created_ats%5Bgt%5D=2017-07-19T00%3A00%3A00Z&order_by=-created_at&per_page=100&space_guids=space-guid&types=audit.service_binding.create"] = responses["/v3/audit_events?order_by=-created_at&per_page=100&space_guids=space-guid&types=audit.service_binding.create"]
+
+		events, err := GetAuditEvents(client, app, since)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Actor).To(Equal("scaler"))
+		Expect(events[1].Actor).To(Equal("sam"))
+	})
+
+	It("returns API errors", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": [{"detail": "You are not authorized to perform the requested action"}]}`)
+		})
+
+		_, err := GetAuditEvents(client, app, time.Time{})
+		Expect(err).To(MatchError("Unable to get audit events: You are not authorized to perform the requested action"))
+	})
+
+	It("annotates findings with the most recent matching change", func() {
+		events, err := GetAuditEvents(client, app, time.Time{})
+		Expect(err).ToNot(HaveOccurred())
+
+		findings := AnnotateFindings([]Finding{
+			{Rule: RuleUnexpectedEnv, Key: "SNOW_FLAKE_VAR"},
+			{Rule: RuleUnexpectedService, Key: "surprise-service"},
+			{Rule: "yaml-coercion"},
+		}, events, app)
+
+		Expect(findings[0].Actor).To(Equal("jane"))
+		Expect(findings[0].Attribution()).To(Equal("last changed by jane at 2017-07-18T10:00:00Z"))
+		Expect(findings[1].Actor).To(Equal("sam"))
+		Expect(findings[2].Actor).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Parse Since", func() {
+	now := time.Date(2017, 7, 23, 12, 0, 0, 0, time.UTC)
+
+	It("parses dates and timestamps", func() {
+		Expect(ParseSince("2017-07-01", now)).To(Equal(time.Date(2017, 7, 1, 0, 0, 0, 0, time.UTC)))
+		Expect(ParseSince("2017-07-01T08:30:00Z", now)).To(Equal(time.Date(2017, 7, 1, 8, 30, 0, 0, time.UTC)))
+	})
+
+	It("parses durations before now", func() {
+		Expect(ParseSince("36h", now)).To(Equal(time.Date(2017, 7, 22, 0, 0, 0, 0, time.UTC)))
+		Expect(ParseSince("7d", now)).To(Equal(time.Date(2017, 7, 16, 12, 0, 0, 0, time.UTC)))
+	})
+
+	It("rejects anything else", func() {
+		_, err := ParseSince("last week", now)
+		Expect(err).To(MatchError("Invalid --since value 'last week', expected a date, timestamp or duration like 72h or 30d"))
+	})
+})
@@ -0,0 +1,205 @@
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odlp/antifreeze/manifest"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// YBindingContract is a companion file declaring, for each app, the
+// credential keys it requires of each bound service:
+//
+//	applications:
+//	- name: my-app
+//	  services:
+//	  - name: postgres-db
+//	    credentials: [uri, username, password]
+type YBindingContract struct {
+	Applications []YBindingApp `yaml:"applications"`
+}
+
+type YBindingApp struct {
+	Name     string            `yaml:"name"`
+	Services []YBindingService `yaml:"services"`
+
+	// Line and ServiceLines locate the app and each service, with its
+	// required credential keys, in the bindings file.
+	Line         int                            `yaml:"-"`
+	ServiceLines map[string]manifest.YItemLines `yaml:"-"`
+}
+
+type YBindingService struct {
+	Name        string   `yaml:"name"`
+	Credentials []string `yaml:"credentials"`
+}
+
+// LoadBindingContract reads the contract for one app. An app the file
+// doesn't mention has no required credentials.
+func LoadBindingContract(contractPath, appName string) (YBindingApp, error) {
+	return loadBindingContract(manifest.Read, contractPath, appName)
+}
+
+// loadBindingContract is LoadBindingContract, reading the file with read.
+func loadBindingContract(read manifest.ReadFunc, contractPath, appName string) (YBindingApp, error) {
+	var contract YBindingContract
+	root, err := manifest.LoadCompanionFileWith(read, contractPath, "bindings file", &contract)
+
+	if err != nil {
+		return YBindingApp{}, err
+	}
+
+	nodes := manifest.ApplicationNodes(root)
+
+	for i, app := range contract.Applications {
+		if app.Name != appName {
+			continue
+		}
+
+		if i < len(nodes) {
+			app.Line = nodes[i].Line
+			if _, services := manifest.MappingEntry(nodes[i], "services"); services != nil {
+				app.ServiceLines = credentialLines(services)
+			}
+		}
+
+		return app, nil
+	}
+
+	return YBindingApp{Name: appName}, nil
+}
+
+// credentialLines locates each service in the contract, and the required
+// credential keys beneath it.
+func credentialLines(services *yaml3.Node) map[string]manifest.YItemLines {
+	items := map[string]manifest.YItemLines{}
+
+	for _, item := range services.Content {
+		item = manifest.ResolveAlias(item)
+		name := manifest.MappingValue(item, "name")
+		if name == nil {
+			continue
+		}
+
+		lines := manifest.YItemLines{Line: item.Line, Keys: map[string]int{}}
+		if credentials := manifest.MappingValue(item, "credentials"); credentials != nil {
+			for _, key := range credentials.Content {
+				lines.Keys[key.Value] = key.Line
+			}
+		}
+		items[name.Value] = lines
+	}
+
+	return items
+}
+
+// CompareBindings checks the credentials of each service bound to the app,
+// as found in its VCAP_SERVICES, have the keys the contract requires. Only
+// the presence of keys is checked; credential values are never reported.
+func CompareBindings(contractPath string, contract YBindingApp, vcapServices interface{}) (findings []Finding) {
+	credentials, err := boundCredentials(vcapServices)
+	if err != nil {
+		return []Finding{{
+			App:      contract.Name,
+			Rule:     RuleBindings,
+			Severity: SeverityWarning,
+			File:     contractPath,
+			Line:     contract.Line,
+			Message:  fmt.Sprintf("Unable to check service credentials for app '%s': %s", contract.Name, err),
+		}}
+	}
+
+	for _, service := range contract.Services {
+		lines := contract.ServiceLines[service.Name]
+		live, ok := credentials[service.Name]
+
+		if !ok {
+			findings = append(findings, Finding{
+				App:      contract.Name,
+				Key:      service.Name,
+				Rule:     RuleMissingBinding,
+				Severity: SeverityError,
+				File:     contractPath,
+				Line:     lines.Line,
+				Message:  fmt.Sprintf("App '%s' has no service '%s' bound, the bindings file requires its credentials", contract.Name, service.Name),
+			})
+			continue
+		}
+
+		// Key names are listed to help spot renames such as `uri` to `url`,
+		// but values stay out of findings.
+		var liveKeys, required []string
+		for k := range live {
+			liveKeys = append(liveKeys, k)
+		}
+		sort.Strings(liveKeys)
+
+		for _, k := range service.Credentials {
+			if _, ok := live[k]; !ok {
+				required = append(required, k)
+			}
+		}
+
+		for _, k := range required {
+			findings = append(findings, Finding{
+				App:      contract.Name,
+				Key:      service.Name,
+				Rule:     RuleMissingCredential,
+				Severity: SeverityError,
+				File:     contractPath,
+				Line:     lines.Keys[k],
+				Message:  fmt.Sprintf("App '%s' service '%s' credentials are missing required key '%s' (has %s)", contract.Name, service.Name, k, keyList(liveKeys)),
+			})
+		}
+	}
+
+	return findings
+}
+
+func keyList(keys []string) string {
+	if len(keys) == 0 {
+		return "no keys"
+	}
+	return "'" + strings.Join(keys, "', '") + "'"
+}
+
+type vcapServiceInstance struct {
+	Name        string                 `json:"name"`
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+// boundCredentials indexes VCAP_SERVICES, which groups instances by service
+// offering, by instance name.
+func boundCredentials(vcapServices interface{}) (map[string]map[string]interface{}, error) {
+	credentials := map[string]map[string]interface{}{}
+	if vcapServices == nil {
+		return credentials, nil
+	}
+
+	b, err := json.Marshal(vcapServices)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse VCAP_SERVICES")
+	}
+
+	var offerings map[string][]vcapServiceInstance
+	if err := json.Unmarshal(b, &offerings); err != nil {
+		return nil, fmt.Errorf("Unable to parse VCAP_SERVICES")
+	}
+
+	for _, instances := range offerings {
+		for _, instance := range instances {
+			credentials[instance.Name] = instance.Credentials
+		}
+	}
+
+	return credentials, nil
+}
+
+const (
+	RuleMissingBinding    = "missing-binding"
+	RuleMissingCredential = "missing-credential"
+	RuleBindings          = "bindings"
+)
@@ -0,0 +1,91 @@
+package check_test
+
+import (
+	. "github.com/odlp/antifreeze/check"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bindings", func() {
+	var contract YBindingApp
+	var vcapServices map[string]interface{}
+
+	BeforeEach(func() {
+		var err error
+		contract, err = LoadBindingContract("../fixtures/bindings.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		vcapServices = map[string]interface{}{
+			"elephantsql": []interface{}{
+				map[string]interface{}{
+					"name":        "postgres-db",
+					"credentials": map[string]interface{}{"uri": "postgres://user:secret@db", "username": "user"},
+				},
+			},
+			"user-provided": []interface{}{
+				map[string]interface{}{
+					"name":        "redis",
+					"credentials": map[string]interface{}{"host": "redis.internal", "password": "hunter2"},
+				},
+			},
+		}
+	})
+
+	It("locates services and credential keys in the bindings file", func() {
+		Expect(contract.Line).To(Equal(3))
+		Expect(contract.ServiceLines["postgres-db"].Line).To(Equal(5))
+		Expect(contract.ServiceLines["postgres-db"].Keys).To(Equal(map[string]int{"uri": 7, "username": 8}))
+		Expect(contract.ServiceLines["redis"].Keys["password"]).To(Equal(10))
+	})
+
+	It("has no findings when every required key is present", func() {
+		Expect(CompareBindings("bindings.yml", contract, vcapServices)).To(BeEmpty())
+	})
+
+	It("reports missing keys without printing credential values", func() {
+		vcapServices["user-provided"] = []interface{}{
+			map[string]interface{}{
+				"name":        "postgres-db",
+				"credentials": map[string]interface{}{"url": "postgres://user:secret@db", "username": "user"},
+			},
+			map[string]interface{}{
+				"name":        "redis",
+				"credentials": map[string]interface{}{"host": "redis.internal", "password": "hunter2"},
+			},
+		}
+		delete(vcapServices, "elephantsql")
+
+		findings := CompareBindings("bindings.yml", contract, vcapServices)
+		Expect(findings).To(Equal([]Finding{{
+			App:      "app-name",
+			Key:      "postgres-db",
+			Rule:     RuleMissingCredential,
+			Severity: SeverityError,
+			File:     "bindings.yml",
+			Line:     7,
+			Message:  "App 'app-name' service 'postgres-db' credentials are missing required key 'uri' (has 'url', 'username')",
+		}}))
+		Expect(findings[0].Message).ToNot(ContainSubstring("secret"))
+	})
+
+	It("reports required services which aren't bound", func() {
+		delete(vcapServices, "user-provided")
+
+		findings := CompareBindings("bindings.yml", contract, vcapServices)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RuleMissingBinding))
+		Expect(findings[0].Key).To(Equal("redis"))
+		Expect(findings[0].Line).To(Equal(9))
+	})
+
+	It("requires nothing of apps the bindings file doesn't mention", func() {
+		contract, err := LoadBindingContract("../fixtures/bindings.yml", "unmentioned-app")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(CompareBindings("bindings.yml", contract, nil)).To(BeEmpty())
+	})
+
+	It("returns an error for a missing bindings file", func() {
+		_, err := LoadBindingContract("../fixtures/missing.yml", "app-name")
+		Expect(err).To(MatchError("Unable to read bindings file: ../fixtures/missing.yml"))
+	})
+})
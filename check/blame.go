@@ -0,0 +1,69 @@
+package check
+
+import (
+	"fmt"
+
+	"github.com/odlp/antifreeze/git"
+)
+
+const RuleGitBlame = "git-blame"
+
+// Blamer finds the commit which last changed a line of a manifest.
+type Blamer func(path string, line int) (git.Commit, error)
+
+// GitBlamer blames manifest lines as of revision, or as the files are now
+// when revision is nil.
+func GitBlamer(revision *git.Revision) Blamer {
+	return func(path string, line int) (git.Commit, error) {
+		return git.Blame(revision, path, line)
+	}
+}
+
+// blockRules are about values missing from the manifest, so their line is
+// the block the value belongs in, or the app's name, rather than a line
+// which declares the key. The commit which last changed it says nothing
+// about the finding.
+var blockRules = []string{
+	RuleUnexpectedEnv,
+	RulePlatformEnv,
+	RuleUnexpectedService,
+	RuleUnexpectedLabel,
+	RuleUnexpectedAnnotation,
+	RuleUnexpectedProcess,
+	RuleUnexpectedSidecar,
+}
+
+// BlameFindings records the commit which last changed the manifest line of
+// each finding about a key declared in one of manifestPaths. When blaming
+// fails, e.g. because a manifest isn't committed, a warning replaces the
+// rest.
+func BlameFindings(findings []Finding, manifestPaths []string, blame Blamer) []Finding {
+	commits := map[string]git.Commit{}
+
+	for i, f := range findings {
+		if f.Key == "" || f.Line == 0 || !stringInSlice(f.File, manifestPaths) || stringInSlice(f.Rule, blockRules) {
+			continue
+		}
+
+		location := fmt.Sprintf("%s:%d", f.File, f.Line)
+		commit, ok := commits[location]
+
+		if !ok {
+			var err error
+			commit, err = blame(f.File, f.Line)
+			if err != nil {
+				return append(findings, Finding{
+					Rule:     RuleGitBlame,
+					Severity: SeverityWarning,
+					File:     f.File,
+					Message:  fmt.Sprintf("Unable to find the commits which last changed the manifest: %s", err),
+				})
+			}
+			commits[location] = commit
+		}
+
+		findings[i].Commit = &commit
+	}
+
+	return findings
+}
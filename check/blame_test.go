@@ -0,0 +1,81 @@
+package check_test
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/git"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Blame", func() {
+	var findings []Finding
+	var blamed []int
+
+	blamer := func(path string, line int) (git.Commit, error) {
+		blamed = append(blamed, line)
+		return git.Commit{Hash: "9fceb02d0ae598e95dc970b74767f19372d61af8", Author: "Jane", Summary: "Bump ENV_VAR_2"}, nil
+	}
+
+	BeforeEach(func() {
+		blamed = nil
+		findings = []Finding{
+			{App: "app-name", Key: "ENV_VAR_2", Rule: RuleChangedEnv, File: "manifest.yml", Line: 9, Message: "changed"},
+			{App: "app-name", Key: "ENV_VAR_3", Rule: RuleChangedEnv, File: "manifest.yml", Line: 9, Message: "changed"},
+			{App: "app-name", Rule: "yaml-coercion", File: "manifest.yml", Line: 8},
+			{App: "app-name", Key: "DATABASE_PASSWORD", Rule: RulePinnedEnv, File: "pins.yml", Line: 3},
+		}
+	})
+
+	It("notes the commit which last changed each key's manifest line", func() {
+		findings = BlameFindings(findings, []string{"manifest.yml"}, blamer)
+
+		Expect(findings[0].Description()).To(Equal("changed (manifest line last changed in 9fceb02 by Jane: Bump ENV_VAR_2)"))
+		Expect(findings[2].Commit).To(BeNil())
+		Expect(findings[3].Commit).To(BeNil())
+		Expect(blamed).To(Equal([]int{9}))
+	})
+
+	It("doesn't blame the block line of keys missing from the manifest", func() {
+		unexpected := []Finding{
+			{App: "app-name", Key: "NEW_VAR", Rule: RuleUnexpectedEnv, File: "manifest.yml", Line: 7},
+			{App: "app-name", Key: "new-db", Rule: RuleUnexpectedService, File: "manifest.yml", Line: 12},
+			{App: "app-name", Key: "team", Rule: RuleUnexpectedLabel, File: "manifest.yml", Line: 2},
+		}
+		findings = BlameFindings(unexpected, []string{"manifest.yml"}, blamer)
+
+		for _, f := range findings {
+			Expect(f.Commit).To(BeNil())
+		}
+		Expect(blamed).To(BeEmpty())
+	})
+
+	It("writes the commit on findings which aren't grouped by app", func() {
+		process := Finding{App: "app-name", Key: "web", Rule: RuleChangedProcess, Severity: SeverityError, File: "manifest.yml", Line: 5, Message: "changed"}
+		findings = BlameFindings([]Finding{process}, []string{"manifest.yml"}, blamer)
+
+		out := &bytes.Buffer{}
+		Expect(WriteFindings(out, OutputText, findings)).To(Succeed())
+		Expect(out.String()).To(Equal("manifest.yml:5: error: changed (manifest line last changed in 9fceb02 by Jane: Bump ENV_VAR_2)\n"))
+	})
+
+	It("notes lines which aren't committed yet", func() {
+		findings = BlameFindings(findings[:1], []string{"manifest.yml"}, func(string, int) (git.Commit, error) {
+			return git.Commit{Hash: "0000000000000000000000000000000000000000"}, nil
+		})
+
+		Expect(findings[0].Description()).To(Equal("changed (manifest line not committed yet)"))
+	})
+
+	It("warns once when blaming fails", func() {
+		findings = BlameFindings(findings, []string{"manifest.yml"}, func(string, int) (git.Commit, error) {
+			return git.Commit{}, errors.New("no such path 'manifest.yml' in HEAD")
+		})
+
+		Expect(findings).To(HaveLen(5))
+		Expect(findings[4].Rule).To(Equal(RuleGitBlame))
+		Expect(findings[4].Message).To(Equal("Unable to find the commits which last changed the manifest: no such path 'manifest.yml' in HEAD"))
+	})
+})
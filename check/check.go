@@ -0,0 +1,581 @@
+// Package check compares apps in Cloud Foundry with their manifest entries,
+// and lints manifests, reporting what it finds as Findings.
+package check
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/git"
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+	"github.com/odlp/antifreeze/source"
+)
+
+// Options configures a Checker.
+type Options struct {
+	// AppName is the app to check. Without one, every app in the manifest
+	// is checked.
+	AppName      string
+	ManifestPath string
+
+	// MergePaths are manifests deep merged over ManifestPath, in order, and
+	// OpsPaths are go-patch ops files applied to the merged manifest before
+	// it's compared with the apps.
+	MergePaths []string
+	OpsPaths   []string
+
+	// Revision, when set, is the git revision to read the manifests, ops
+	// files and companion files at, rather than the working tree. Blame records the commit
+	// which last changed the manifest line of each finding.
+	Revision *git.Revision
+	Blame    bool
+
+	// Since limits attributing findings to changes made after it.
+	Since time.Time
+
+	// IgnoreMetadataPrefixes are label and annotation prefixes left out of
+	// the metadata check, on top of DefaultIgnoredMetadataPrefixes.
+	IgnoreMetadataPrefixes []string
+
+	// BindingsPath is a companion file declaring the credential keys the
+	// app requires of its bound services.
+	BindingsPath string
+
+	// PinsPath is a companion file pinning secret ENV vars to the digest of
+	// their value.
+	PinsPath string
+
+	// PolicyPath is a file of house rules the app must follow.
+	PolicyPath string
+
+	// RemediationValues puts the app's ENV values into the suggested
+	// manifest snippets, rather than `((KEY))` variables. Values which look
+	// like secrets stay variables.
+	RemediationValues bool
+
+	// Concurrency is how many apps are checked at once when every app in
+	// the manifest is checked. Timeout limits each request, and requests
+	// failing with a transient error are retried up to Retries times,
+	// waiting Backoff before the first retry and twice as long each time
+	// after.
+	Concurrency int
+	Timeout     time.Duration
+	Retries     int
+	Backoff     time.Duration
+
+	// Transport, when set, makes the Cloud Controller requests, e.g. to
+	// record or replay them.
+	Transport http.RoundTripper
+}
+
+// Checker compares apps in Cloud Foundry with their manifest entries and
+// reports anything the manifest is missing or disagrees with, attributed to
+// whoever last made a matching change.
+type Checker struct {
+	connection plugin.CliConnection
+	options    Options
+}
+
+// NewChecker checks apps through a cf CLI plugin connection, or any other
+// source of app state, such as a source.StandaloneConnection.
+func NewChecker(cliConnection plugin.CliConnection, options Options) *Checker {
+	return &Checker{connection: cliConnection, options: options}
+}
+
+// Check checks the named app, or every app in the manifest when no app is
+// named. Findings include the lint warnings which apply to the apps
+// checked.
+func (c *Checker) Check() ([]Finding, error) {
+	var findings []Finding
+
+	for _, manifestPath := range c.options.manifestPaths() {
+		lint, err := lintForCheck(c.options.read(), manifestPath, c.options.AppName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		findings = append(findings, lint...)
+	}
+
+	connection := newSerialConnection(c.connection, c.options)
+
+	var drift []Finding
+	var err error
+	if c.options.AppName != "" {
+		drift, err = checkManifest(connection, c.options)
+	} else {
+		drift, err = checkManifests(connection, c.options)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.options.Blame {
+		drift = BlameFindings(drift, c.options.manifestPaths(), GitBlamer(c.options.Revision))
+	}
+
+	return append(findings, drift...), nil
+}
+
+// checkManifest checks the named app.
+func checkManifest(cliConnection plugin.CliConnection, options Options) ([]Finding, error) {
+	document, err := manifest.LoadMergedWith(options.read(), options.manifestPaths(), options.OpsPaths)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifestApp, err := document.Application(options.AppName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	companions, err := loadCompanions(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := cliConnection.GetApp(options.AppName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get app '%s': %s", options.AppName, err)
+	}
+
+	findings := checkAppState(cliConnection, options, manifestApp, companions, source.AppState{Model: app}, nil)
+
+	if HasErrors(findings) {
+		events, err := GetAuditEvents(newClient(cliConnection, options), app, options.Since)
+		findings = annotateApp(options, manifestApp, app, findings, events, err)
+	}
+
+	return locateFindings(document, options, findings), nil
+}
+
+func (o Options) manifestPaths() []string {
+	return append([]string{o.ManifestPath}, o.MergePaths...)
+}
+
+// read reads manifests, ops files and companion files at the revision, if
+// there is one.
+func (o Options) read() manifest.ReadFunc {
+	if o.Revision != nil {
+		return o.Revision.ReadFile
+	}
+	return manifest.Read
+}
+
+// locateFindings points findings against a merged manifest at the file
+// which contributed the offending key.
+func locateFindings(document manifest.YManifest, options Options, findings []Finding) []Finding {
+	for i, f := range findings {
+		if f.File == options.ManifestPath {
+			findings[i].File, findings[i].Line = document.Locate(f.Line)
+		}
+	}
+	return findings
+}
+
+// companions are the optional files read alongside the manifest.
+type companions struct {
+	contract YBindingApp
+	pins     YPinnedApp
+	policy   YPolicy
+}
+
+func loadCompanions(options Options) (c companions, err error) {
+	if options.BindingsPath != "" {
+		c.contract, err = loadBindingContract(options.read(), options.BindingsPath, options.AppName)
+		if err != nil {
+			return companions{}, err
+		}
+	}
+
+	if options.PinsPath != "" {
+		c.pins, err = loadPins(options.read(), options.PinsPath, options.AppName)
+		if err != nil {
+			return companions{}, err
+		}
+	}
+
+	if options.PolicyPath != "" {
+		c.policy, err = loadPolicy(options.read(), options.PolicyPath)
+		if err != nil {
+			return companions{}, err
+		}
+	}
+
+	return c, nil
+}
+
+func newClient(cliConnection plugin.CliConnection, options Options) *cc.Client {
+	client := cc.NewClient(cliConnection)
+	if options.Transport != nil {
+		client.SetHTTPClient(&http.Client{Transport: options.Transport})
+	}
+	client.SetRetries(options.Retries, options.Backoff)
+	if options.Timeout > 0 {
+		client.SetTimeout(options.Timeout)
+	}
+	return client
+}
+
+// checkAppState runs every check against an app which has been fetched.
+// When the app was fetched along with the rest of its space, space holds
+// what every app shares, so it isn't fetched again for each app. Findings
+// are attributed to audit events by the caller.
+func checkAppState(cliConnection plugin.CliConnection, options Options, manifestApp manifest.YApplication, companions companions, state source.AppState, space *spaceState) []Finding {
+	app := state.Model
+	client := newClient(cliConnection, options)
+
+	findings := CompareApp(options.ManifestPath, manifestApp, app)
+	if options.RemediationValues {
+		findings = RevealEnvValues(findings, app.EnvironmentVars)
+	}
+	findings = append(findings, SniffSecrets(options.ManifestPath, manifestApp, app)...)
+
+	sources, sourcesErr := getEnvSources(client, companions.contract, app, space)
+	if sourcesErr != nil {
+		findings = append(findings, unableTo(options.ManifestPath, manifestApp, RuleEnvSources, "find where ENV vars come from", sourcesErr))
+	} else {
+		findings = AttributeEnvSources(findings, sources)
+		findings = append(findings, CompareEnvGroups(options.ManifestPath, manifestApp, sources)...)
+	}
+
+	if options.PinsPath != "" {
+		findings = append(WithoutPinnedEnv(findings, companions.pins), ComparePins(options.PinsPath, companions.pins, app)...)
+	}
+
+	if options.BindingsPath != "" {
+		if sourcesErr != nil {
+			findings = append(findings, unableTo(options.ManifestPath, manifestApp, RuleBindings, "check service credentials", sourcesErr))
+		} else {
+			findings = append(findings, CompareBindings(options.BindingsPath, companions.contract, sources.System["VCAP_SERVICES"])...)
+		}
+	}
+
+	if state.Bulk {
+		findings = append(findings, CompareProcesses(options.ManifestPath, manifestApp, state.Processes)...)
+	} else {
+		processes, err := CheckProcesses(client, app.Guid, options.ManifestPath, manifestApp)
+		if err != nil {
+			findings = append(findings, unableTo(options.ManifestPath, manifestApp, RuleProcesses, "check processes", err))
+		}
+		findings = append(findings, processes...)
+	}
+
+	// Sidecars can only be listed app by app, so when the whole space is
+	// checked they're only fetched for apps which declare some.
+	if !state.Bulk || len(manifestApp.Sidecars) > 0 {
+		sidecars, err := CheckSidecars(client, app.Guid, options.ManifestPath, manifestApp)
+		if err != nil {
+			findings = append(findings, unableTo(options.ManifestPath, manifestApp, RuleSidecars, "check sidecars", err))
+		}
+		findings = append(findings, sidecars...)
+	}
+
+	v3App := state.App
+	var appErr error
+	if !state.Bulk {
+		v3App, appErr = client.GetApp(app.Guid)
+	}
+
+	if appErr != nil {
+		findings = append(findings, unableTo(options.ManifestPath, manifestApp, RuleMetadata, "check metadata and docker image", appErr))
+	} else {
+		findings = append(findings, CompareMetadata(options.ManifestPath, manifestApp, v3App.Metadata, options.IgnoreMetadataPrefixes)...)
+
+		docker, err := CheckDocker(client, v3App, options.ManifestPath, manifestApp)
+		if err != nil {
+			findings = append(findings, unableTo(options.ManifestPath, manifestApp, RuleDocker, "check docker image", err))
+		}
+		findings = append(findings, docker...)
+	}
+
+	if len(companions.policy.Rules) > 0 {
+		if space != nil {
+			findings = append(findings, EvaluatePolicy(options.ManifestPath, companions.policy, manifestApp, app, space.space.Name)...)
+		} else {
+			violations, err := CheckPolicy(cliConnection, options.ManifestPath, companions.policy, manifestApp, app)
+			if err != nil {
+				findings = append(findings, unableTo(options.ManifestPath, manifestApp, RulePolicy, "evaluate policy", err))
+			}
+			findings = append(findings, violations...)
+		}
+	}
+
+	return findings
+}
+
+// getEnvSources finds where an app's ENV vars come from. An app fetched
+// with its space already has its own ENV vars, and shares the space's env
+// groups, so its system env is only fetched when the bindings file requires
+// credentials from its services.
+func getEnvSources(client *cc.Client, contract YBindingApp, app plugin_models.GetAppModel, space *spaceState) (EnvSources, error) {
+	if space == nil {
+		return GetEnvSources(client, app.Guid)
+	}
+
+	if space.envGroupsErr != nil {
+		return EnvSources{}, space.envGroupsErr
+	}
+
+	sources := EnvSources{
+		UserProvided: app.EnvironmentVars,
+		RunningGroup: space.runningGroup,
+		StagingGroup: space.stagingGroup,
+	}
+
+	if len(contract.Services) > 0 {
+		env, err := client.GetAppEnvironment(app.Guid)
+		if err != nil {
+			return EnvSources{}, err
+		}
+		sources.System = env.SystemEnvJSON
+	}
+
+	return sources, nil
+}
+
+// annotateApp attributes an app's findings to the audit events which were
+// looked up for it, or reports why they couldn't be.
+func annotateApp(options Options, manifestApp manifest.YApplication, app plugin_models.GetAppModel, findings []Finding, events []AuditEvent, err error) []Finding {
+	if err != nil {
+		return append(findings, unableTo(options.ManifestPath, manifestApp, RuleAuditEvents, "attribute findings", err))
+	}
+
+	return AnnotateFindings(findings, events, app)
+}
+
+// unableTo reports a check which couldn't be completed as a warning, so one
+// unavailable API doesn't hide the results of the others.
+func unableTo(manifestPath string, manifestApp manifest.YApplication, rule, action string, err error) Finding {
+	return Finding{
+		App:      manifestApp.Name,
+		Rule:     rule,
+		Severity: SeverityWarning,
+		File:     manifestPath,
+		Line:     manifestApp.Lines.Name,
+		Message:  fmt.Sprintf("Unable to %s for app '%s': %s", action, manifestApp.Name, err),
+	}
+}
+
+// CompareApp lists the differences between an app and its manifest entry.
+// Unexpected values point at the manifest block they should be added to;
+// changed values point at the offending key.
+func CompareApp(manifestPath string, manifestApp manifest.YApplication, app plugin_models.GetAppModel) (findings []Finding) {
+	var manifestEnv, appEnv []string
+
+	for k := range manifestApp.Env {
+		manifestEnv = append(manifestEnv, k)
+	}
+
+	for k := range app.EnvironmentVars {
+		appEnv = append(appEnv, k)
+	}
+
+	sort.Strings(manifestEnv)
+	sort.Strings(appEnv)
+
+	unexpectedEnv := MissingFromManifest(manifestEnv, appEnv)
+	envTypos := pairTypos(unexpectedEnv, MissingFromManifest(appEnv, manifestEnv))
+
+	for _, k := range unexpectedEnv {
+		if manifestKey, ok := envTypos[k]; ok {
+			findings = append(findings, Finding{
+				App:         manifestApp.Name,
+				Key:         k,
+				ManifestKey: manifestKey,
+				Rule:        RuleTypoEnv,
+				Severity:    SeverityError,
+				File:        manifestPath,
+				Line:        manifestApp.Lines.EnvKeys[manifestKey],
+				Message:     fmt.Sprintf("App '%s' has ENV var '%s' where the manifest has '%s' (possible rename/typo)", manifestApp.Name, k, manifestKey),
+				Remediation: typoEnvRemediation(manifestApp, k, manifestKey),
+			})
+			continue
+		}
+
+		findings = append(findings, Finding{
+			App:      manifestApp.Name,
+			Key:      k,
+			Rule:     RuleUnexpectedEnv,
+			Severity: SeverityError,
+			File:     manifestPath,
+			Line:     blockLine(manifestApp.Lines.Env, manifestApp.Lines),
+			Message:  fmt.Sprintf("App '%s' has unexpected ENV var '%s' (missing from manifest)", manifestApp.Name, k),
+
+			Remediation: envRemediation(manifestApp, k, cfCommand("unset-env", manifestApp.Name, k)),
+		})
+	}
+
+	for _, k := range appEnv {
+		manifestValue, ok := manifestApp.Env[k]
+		if !ok {
+			continue
+		}
+
+		message := fmt.Sprintf("App '%s' has ENV var '%s' with a value different from the manifest", manifestApp.Name, k)
+		remediation := envRemediation(manifestApp, k, cfCommand("set-env", manifestApp.Name, k, manifest.EnvValueString(manifestValue)))
+
+		if digest, pinned := ParsePin(manifestValue); pinned {
+			if EnvDigest(app.EnvironmentVars[k]) == digest {
+				continue
+			}
+			message = fmt.Sprintf("App '%s' has ENV var '%s' with a value which doesn't match its pinned digest", manifestApp.Name, k)
+			remediation = pinRemediation(manifestApp, k, app.EnvironmentVars[k])
+		} else if envValuesMatch(manifestValue, app.EnvironmentVars[k]) {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			App:      manifestApp.Name,
+			Key:      k,
+			Rule:     RuleChangedEnv,
+			Severity: SeverityError,
+			File:     manifestPath,
+			Line:     manifestApp.Lines.EnvKeys[k],
+			Message:  message,
+
+			Remediation: remediation,
+		})
+	}
+
+	var appServices []string
+	for _, s := range app.Services {
+		appServices = append(appServices, s.Name)
+	}
+
+	sort.Strings(appServices)
+
+	unexpectedServices := MissingFromManifest(manifestApp.Services, appServices)
+	serviceTypos := pairTypos(unexpectedServices, MissingFromManifest(appServices, manifestApp.Services))
+
+	for _, s := range unexpectedServices {
+		if manifestName, ok := serviceTypos[s]; ok {
+			findings = append(findings, Finding{
+				App:         manifestApp.Name,
+				Key:         s,
+				ManifestKey: manifestName,
+				Rule:        RuleTypoService,
+				Severity:    SeverityError,
+				File:        manifestPath,
+				Line:        manifestApp.Lines.ServiceNames[manifestName],
+				Message:     fmt.Sprintf("App '%s' has service '%s' where the manifest has '%s' (possible rename/typo)", manifestApp.Name, s, manifestName),
+				Remediation: typoServiceRemediation(manifestApp, s, manifestName),
+			})
+			continue
+		}
+
+		findings = append(findings, Finding{
+			App:      manifestApp.Name,
+			Key:      s,
+			Rule:     RuleUnexpectedService,
+			Severity: SeverityError,
+			File:     manifestPath,
+			Line:     blockLine(manifestApp.Lines.Services, manifestApp.Lines),
+			Message:  fmt.Sprintf("App '%s' has unexpected service '%s' (missing from manifest)", manifestApp.Name, s),
+
+			Remediation: serviceRemediation(manifestApp, s),
+		})
+	}
+
+	return findings
+}
+
+// pairTypos matches values the app has but the manifest lacks with values
+// the manifest declares but the app lacks, when they're close enough to be
+// a rename or typo. It maps each paired app value to its manifest value.
+func pairTypos(unexpected, missing []string) map[string]string {
+	type candidate struct {
+		unexpected, missing string
+		distance            int
+	}
+
+	var candidates []candidate
+	for _, u := range unexpected {
+		for _, m := range missing {
+			if d := editDistance(u, m); isLikelyTypo(u, m, d) {
+				candidates = append(candidates, candidate{u, m, d})
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	pairs := map[string]string{}
+	paired := map[string]bool{}
+
+	for _, c := range candidates {
+		if _, ok := pairs[c.unexpected]; ok || paired[c.missing] {
+			continue
+		}
+		pairs[c.unexpected] = c.missing
+		paired[c.missing] = true
+	}
+
+	return pairs
+}
+
+// blockLine falls back to the application's name when the block a value
+// belongs in hasn't been declared yet.
+func blockLine(line int, lines manifest.YLines) int {
+	if line == 0 {
+		return lines.Name
+	}
+	return line
+}
+
+// GetAppEnvAndServices lists the names of an app's ENV vars and services.
+func GetAppEnvAndServices(cliConnection plugin.CliConnection, appName string) (appEnv []string, appServices []string, err error) {
+	app, _ := cliConnection.GetApp(appName)
+
+	for k := range app.EnvironmentVars {
+		appEnv = append(appEnv, k)
+	}
+
+	for _, s := range app.Services {
+		appServices = append(appServices, s.Name)
+	}
+
+	return appEnv, appServices, err
+}
+
+// MissingFromManifest lists the values the app has which the manifest
+// doesn't.
+func MissingFromManifest(manifestList, appList []string) (missing []string) {
+	for _, appValue := range appList {
+		if !stringInSlice(appValue, manifestList) {
+			missing = append(missing, appValue)
+		}
+	}
+	return missing
+}
+
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	RuleUnexpectedEnv     = "unexpected-env"
+	RuleChangedEnv        = "changed-env"
+	RuleUnexpectedService = "unexpected-service"
+	RuleTypoEnv           = "possible-typo-env"
+	RuleTypoService       = "possible-typo-service"
+	RuleAuditEvents       = "audit-events"
+)
@@ -0,0 +1,467 @@
+package check_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Check Manifest", func() {
+	var cliConnection *pluginfakes.FakeCliConnection
+	var fakeApp plugin_models.GetAppModel
+	var options Options
+	var server *httptest.Server
+	var runningGroup string
+	var auditRequests int
+	var auditStatus int
+
+	BeforeEach(func() {
+		runningGroup = `{}`
+		auditRequests = 0
+		auditStatus = http.StatusOK
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/v3/apps/app-guid/env", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"environment_variables": fakeApp.EnvironmentVars,
+				"system_env_json":       map[string]interface{}{},
+			})
+		})
+		mux.HandleFunc("/v3/environment_variable_groups/running", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"var": %s}`, runningGroup)
+		})
+		mux.HandleFunc("/v3/environment_variable_groups/staging", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"var": {}}`)
+		})
+		mux.HandleFunc("/v3/apps/app-guid/processes", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"pagination": {}, "resources": [{"type": "web", "instances": 1, "memory_in_mb": 256}]}`)
+		})
+		mux.HandleFunc("/v3/apps/app-guid/sidecars", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"pagination": {}, "resources": []}`)
+		})
+		mux.HandleFunc("/v3/audit_events", func(w http.ResponseWriter, r *http.Request) {
+			auditRequests++
+			w.WriteHeader(auditStatus)
+			if auditStatus != http.StatusOK {
+				fmt.Fprint(w, `{"errors": [{"detail": "You are not authorized to perform the requested action"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"pagination": {}, "resources": []}`)
+		})
+		mux.HandleFunc("/v3/apps/app-guid", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"guid": "app-guid", "name": "app-name", "lifecycle": {"type": "buildpack"}, "metadata": {"labels": {}, "annotations": {}}}`)
+		})
+		server = httptest.NewServer(mux)
+
+		options = Options{ManifestPath: "../fixtures/manifest.yml", AppName: "app-name"}
+		cliConnection = &pluginfakes.FakeCliConnection{}
+		cliConnection.ApiEndpointReturns(server.URL, nil)
+		cliConnection.AccessTokenReturns("bearer token", nil)
+		fakeApp = plugin_models.GetAppModel{
+			Guid: "app-guid",
+			EnvironmentVars: map[string]interface{}{
+				"ENV_VAR_1": float64(1800),
+				"ENV_VAR_2": "https://example.com",
+				"ENV_SNOW":  "flake",
+			},
+			Services: []plugin_models.GetApp_ServiceSummary{
+				{Name: "service-1"},
+				{Name: "surprise-service"},
+			},
+		}
+
+		cliConnection.GetAppStub = func(arg1 string) (plugin_models.GetAppModel, error) {
+			Expect(arg1).To(Equal("app-name"))
+			return fakeApp, nil
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("points unexpected ENV vars at the app's env block", func() {
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(findings).To(ContainElement(Finding{
+			App:      "app-name",
+			Key:      "ENV_SNOW",
+			Rule:     RuleUnexpectedEnv,
+			Severity: SeverityError,
+			File:     "../fixtures/manifest.yml",
+			Line:     9,
+			Message:  "App 'app-name' has unexpected ENV var 'ENV_SNOW' (missing from manifest)",
+			Source:   EnvSourceUserProvided,
+
+			Remediation: &Remediation{
+				Manifest: "env:\n  ENV_SNOW: ((ENV_SNOW))",
+				Ops:      []manifest.Op{{Type: "replace", Path: "/applications/name=app-name/env/ENV_SNOW?", Value: "((ENV_SNOW))"}},
+				Command:  "cf unset-env app-name ENV_SNOW",
+			},
+		}))
+	})
+
+	It("applies ops files to the manifest before comparing", func() {
+		options.OpsPaths = []string{"../fixtures/ops/prod.yml"}
+
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(findings).To(BeEmpty())
+	})
+
+	It("points findings against merged manifests at the file which contributed the key", func() {
+		options = Options{ManifestPath: "../fixtures/merge/base.yml", MergePaths: []string{"../fixtures/merge/prod.yml"}, AppName: "app-name"}
+		fakeApp.EnvironmentVars["ENV_VAR_2"] = "https://pivotal.io"
+
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+
+		located := map[string]string{}
+		for _, f := range findings {
+			located[f.Key] = fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+
+		Expect(located).To(HaveKeyWithValue("ENV_VAR_2", "../fixtures/merge/prod.yml:12"))
+		Expect(located).To(HaveKeyWithValue("ENV_SNOW", "../fixtures/merge/base.yml:10"))
+		Expect(located).To(HaveKeyWithValue("surprise-service", "../fixtures/merge/base.yml:8"))
+	})
+
+	It("includes the lint warnings for the app", func() {
+		options = Options{ManifestPath: "../fixtures/coercion-manifest.yml", AppName: "app-1"}
+		cliConnection.GetAppReturns(fakeApp, nil)
+		cliConnection.GetAppStub = nil
+
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+
+		var lintApps []string
+		for _, f := range findings {
+			if f.Rule == "yaml-coercion" {
+				lintApps = append(lintApps, f.App)
+			}
+		}
+		Expect(lintApps).ToNot(BeEmpty())
+		Expect(lintApps).ToNot(ContainElement("app-2"))
+	})
+
+	It("points changed ENV values at the offending key", func() {
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(findings).To(ContainElement(Finding{
+			App:      "app-name",
+			Key:      "ENV_VAR_2",
+			Rule:     RuleChangedEnv,
+			Severity: SeverityError,
+			File:     "../fixtures/manifest.yml",
+			Line:     11,
+			Message:  "App 'app-name' has ENV var 'ENV_VAR_2' with a value different from the manifest",
+			Source:   EnvSourceUserProvided,
+
+			Remediation: &Remediation{
+				Manifest: "env:\n  ENV_VAR_2: ((ENV_VAR_2))",
+				Ops:      []manifest.Op{{Type: "replace", Path: "/applications/name=app-name/env/ENV_VAR_2?", Value: "((ENV_VAR_2))"}},
+				Command:  "cf set-env app-name ENV_VAR_2 https://pivotal.io",
+			},
+		}))
+	})
+
+	It("compares values the way they reach the app", func() {
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, f := range findings {
+			Expect(f.Key).ToNot(Equal("ENV_VAR_1"))
+		}
+	})
+
+	It("points unexpected services at the app's services block", func() {
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(findings).To(HaveLen(3))
+		Expect(findings[2].Rule).To(Equal(RuleUnexpectedService))
+		Expect(findings[2].Key).To(Equal("surprise-service"))
+		Expect(findings[2].Line).To(Equal(6))
+	})
+
+	Context("app without an env block in the manifest", func() {
+		It("points at the app's name", func() {
+			app := manifest.YApplication{Name: "app-name", Lines: manifest.YLines{Name: 3}}
+			findings := CompareApp("manifest.yml", app, fakeApp)
+
+			Expect(findings).To(HaveLen(5))
+			Expect(findings[0].Line).To(Equal(3))
+		})
+	})
+
+	Context("keys which look like typos", func() {
+		var manifestApp manifest.YApplication
+
+		BeforeEach(func() {
+			manifestApp = manifest.YApplication{
+				Name:     "app-name",
+				Env:      map[string]interface{}{"DATABSE_URL": "postgres://db", "ID": "1"},
+				Services: []string{"postgres-db", "redis"},
+				Lines: manifest.YLines{
+					Env:          5,
+					EnvKeys:      map[string]int{"DATABSE_URL": 6, "ID": 7},
+					Services:     8,
+					ServiceNames: map[string]int{"postgres-db": 9, "redis": 10},
+				},
+			}
+			fakeApp = plugin_models.GetAppModel{
+				EnvironmentVars: map[string]interface{}{"DATABASE_URL": "postgres://db", "DB": "1"},
+				Services: []plugin_models.GetApp_ServiceSummary{
+					{Name: "postgres-db2"},
+					{Name: "redis"},
+				},
+			}
+		})
+
+		It("pairs unexpected ENV vars with close manifest keys", func() {
+			findings := CompareApp("manifest.yml", manifestApp, fakeApp)
+
+			Expect(findings).To(ContainElement(Finding{
+				App:         "app-name",
+				Key:         "DATABASE_URL",
+				ManifestKey: "DATABSE_URL",
+				Rule:        RuleTypoEnv,
+				Severity:    SeverityError,
+				File:        "manifest.yml",
+				Line:        6,
+				Message:     "App 'app-name' has ENV var 'DATABASE_URL' where the manifest has 'DATABSE_URL' (possible rename/typo)",
+				Remediation: &Remediation{
+					Manifest: "env:\n  DATABASE_URL: ((DATABASE_URL))",
+					Ops: []manifest.Op{
+						{Type: "remove", Path: "/applications/name=app-name/env/DATABSE_URL"},
+						{Type: "replace", Path: "/applications/name=app-name/env/DATABASE_URL?", Value: "((DATABASE_URL))"},
+					},
+					Command: "cf unset-env app-name DATABASE_URL",
+				},
+			}))
+		})
+
+		It("doesn't pair short keys which merely look alike", func() {
+			findings := CompareApp("manifest.yml", manifestApp, fakeApp)
+
+			Expect(findings).To(ContainElement(Finding{
+				App:      "app-name",
+				Key:      "DB",
+				Rule:     RuleUnexpectedEnv,
+				Severity: SeverityError,
+				File:     "manifest.yml",
+				Line:     5,
+				Message:  "App 'app-name' has unexpected ENV var 'DB' (missing from manifest)",
+
+				Remediation: &Remediation{
+					Manifest: "env:\n  DB: ((DB))",
+					Ops:      []manifest.Op{{Type: "replace", Path: "/applications/name=app-name/env/DB?", Value: "((DB))"}},
+					Command:  "cf unset-env app-name DB",
+				},
+			}))
+		})
+
+		It("pairs unexpected services with close manifest services", func() {
+			findings := CompareApp("manifest.yml", manifestApp, fakeApp)
+
+			Expect(findings).To(HaveLen(3))
+			Expect(findings[2].Rule).To(Equal(RuleTypoService))
+			Expect(findings[2].Key).To(Equal("postgres-db2"))
+			Expect(findings[2].ManifestKey).To(Equal("postgres-db"))
+			Expect(findings[2].Line).To(Equal(9))
+		})
+	})
+
+	Context("ENV var from the running env group", func() {
+		It("warns rather than asking for it in the manifest", func() {
+			runningGroup = `{"HTTP_PROXY": "http://proxy", "ENV_SNOW": "slush"}`
+
+			findings, err := NewChecker(cliConnection, options).Check()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(findings).To(ContainElement(Finding{
+				App:      "app-name",
+				Key:      "HTTP_PROXY",
+				Rule:     RulePlatformEnv,
+				Severity: SeverityWarning,
+				File:     "../fixtures/manifest.yml",
+				Line:     9,
+				Message:  "App 'app-name' has ENV var 'HTTP_PROXY' from the running env group, which is managed by the platform and doesn't belong in the manifest",
+				Source:   EnvSourceRunningGroup,
+			}))
+
+			for _, f := range findings {
+				if f.Key == "ENV_SNOW" {
+					Expect(f.Source).To(Equal(EnvSourceUserProvided), "the app's own value overrides the group's")
+				}
+			}
+		})
+
+		It("reports a group value which overrides the manifest's", func() {
+			delete(fakeApp.EnvironmentVars, "ENV_VAR_2")
+			runningGroup = `{"ENV_VAR_2": "https://proxy.example.com"}`
+
+			findings, err := NewChecker(cliConnection, options).Check()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(findings).To(ContainElement(Finding{
+				App:      "app-name",
+				Key:      "ENV_VAR_2",
+				Rule:     RuleChangedPlatformEnv,
+				Severity: SeverityError,
+				File:     "../fixtures/manifest.yml",
+				Line:     11,
+				Message:  "App 'app-name' has ENV var 'ENV_VAR_2' from the running env group with a value different from the manifest",
+				Source:   EnvSourceRunningGroup,
+
+				Remediation: &Remediation{Command: "cf set-env app-name ENV_VAR_2 https://pivotal.io"},
+			}))
+		})
+	})
+
+	Context("audit events can't be fetched", func() {
+		It("warns that findings can't be attributed", func() {
+			auditStatus = http.StatusForbidden
+
+			findings, err := NewChecker(cliConnection, options).Check()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(findings).To(HaveLen(4))
+			Expect(findings[3]).To(Equal(Finding{
+				App:      "app-name",
+				Rule:     RuleAuditEvents,
+				Severity: SeverityWarning,
+				File:     "../fixtures/manifest.yml",
+				Line:     3,
+				Message:  "Unable to attribute findings for app 'app-name': Unable to get audit events: You are not authorized to perform the requested action",
+			}))
+		})
+	})
+
+	Context("app matches the manifest", func() {
+		It("doesn't look up audit events", func() {
+			fakeApp = plugin_models.GetAppModel{
+				Guid:            "app-guid",
+				EnvironmentVars: map[string]interface{}{"ENV_VAR_1": float64(1800)},
+			}
+
+			findings, err := NewChecker(cliConnection, options).Check()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(findings).To(BeEmpty())
+			Expect(auditRequests).To(Equal(0))
+		})
+	})
+
+	Context("processes can't be fetched", func() {
+		It("warns that processes weren't checked", func() {
+			server.Close()
+
+			findings, err := NewChecker(cliConnection, options).Check()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(findings[3].Rule).To(Equal(RuleEnvSources))
+			Expect(findings[3].Severity).To(Equal(SeverityWarning))
+			Expect(findings[4].Rule).To(Equal(RuleProcesses))
+			Expect(findings[4].Severity).To(Equal(SeverityWarning))
+			Expect(findings[4].Message).To(HavePrefix("Unable to check processes for app 'app-name': Unable to reach the Cloud Controller"))
+		})
+	})
+
+	Context("app can't be fetched", func() {
+		It("returns an error", func() {
+			cliConnection.GetAppStub = nil
+			cliConnection.GetAppReturns(plugin_models.GetAppModel{}, errors.New("App app-name not found"))
+
+			_, err := NewChecker(cliConnection, options).Check()
+			Expect(err).To(MatchError("Unable to get app 'app-name': App app-name not found"))
+		})
+	})
+})
+
+var _ = Describe("Get App Env And Services", func() {
+	var cliConnection *pluginfakes.FakeCliConnection
+	var fakeApp plugin_models.GetAppModel
+
+	BeforeEach(func() {
+		cliConnection = &pluginfakes.FakeCliConnection{}
+		fakeAppEnv := map[string]interface{}{"ENV_VAR_1": "foo", "ENV_VAR_2": "bar"}
+		service1 := plugin_models.GetApp_ServiceSummary{
+			Name: "service-1",
+		}
+		service2 := plugin_models.GetApp_ServiceSummary{
+			Name: "service-2",
+		}
+		fakeApp = plugin_models.GetAppModel{
+			EnvironmentVars: fakeAppEnv,
+			Services:        []plugin_models.GetApp_ServiceSummary{service1, service2},
+		}
+
+		cliConnection.GetAppStub = func(arg1 string) (plugin_models.GetAppModel, error) {
+			Expect(arg1).To(Equal("app-name"))
+			return fakeApp, nil
+		}
+	})
+
+	It("returns the ENV keys from the application", func() {
+		appEnv, _, err := GetAppEnvAndServices(cliConnection, "app-name")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(appEnv).To(HaveLen(2))
+		Expect(appEnv).To(ContainElement("ENV_VAR_1"))
+		Expect(appEnv).To(ContainElement("ENV_VAR_2"))
+	})
+
+	It("returns the service keys from the application", func() {
+		_, appServices, err := GetAppEnvAndServices(cliConnection, "app-name")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(appServices).To(HaveLen(2))
+		Expect(appServices).To(ContainElement("service-1"))
+		Expect(appServices).To(ContainElement("service-2"))
+	})
+})
+
+var _ = Describe("Missing From Manifest", func() {
+	Context("no differences", func() {
+		It("returns an empty slice", func() {
+			manifestEnv := []string{"ENV_VAR_1"}
+			appEnv := []string{"ENV_VAR_1"}
+
+			difference := MissingFromManifest(manifestEnv, appEnv)
+			Expect(difference).To(HaveLen(0))
+		})
+	})
+
+	Context("manifest has additional values", func() {
+		It("returns an empty slice", func() {
+			manifestEnv := []string{"ENV_VAR_1", "ENV_VAR_2"}
+			appEnv := []string{"ENV_VAR_1"}
+
+			difference := MissingFromManifest(manifestEnv, appEnv)
+			Expect(difference).To(HaveLen(0))
+		})
+	})
+
+	Context("app has additional values", func() {
+		It("returns the offending values", func() {
+			manifestEnv := []string{"ENV_VAR_1"}
+			appEnv := []string{"ENV_VAR_1", "ENV_SNOW", "ENV_FLAKE"}
+
+			difference := MissingFromManifest(manifestEnv, appEnv)
+			Expect(difference).To(HaveLen(2))
+			Expect(difference).To(ContainElement("ENV_SNOW"))
+			Expect(difference).To(ContainElement("ENV_FLAKE"))
+		})
+	})
+})
@@ -0,0 +1,159 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+// CheckDocker compares how an app is run, from a buildpack or a docker
+// image, and which image and registry username, with the manifest's
+// `docker:` block. The image and username come from the package the app's
+// current droplet was staged from.
+func CheckDocker(client *cc.Client, app cc.App, manifestPath string, manifestApp manifest.YApplication) ([]Finding, error) {
+	if app.Lifecycle.Type != dockerLifecycle {
+		return CompareDocker(manifestPath, manifestApp, app.Lifecycle.Type, cc.Package{}), nil
+	}
+
+	droplet, err := client.GetCurrentDroplet(app.GUID)
+	if cc.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := client.GetPackage(droplet.PackageGUID())
+	if err != nil {
+		return nil, err
+	}
+
+	return CompareDocker(manifestPath, manifestApp, app.Lifecycle.Type, pkg), nil
+}
+
+// CompareDocker reports switches between buildpack and docker apps, and
+// docker images or usernames which differ from the manifest.
+func CompareDocker(manifestPath string, manifestApp manifest.YApplication, lifecycle string, pkg cc.Package) (findings []Finding) {
+	declared := manifestApp.Docker
+	lines := manifestApp.Lines.Docker
+
+	finding := func(rule, attribute, expected, actual string, line int, format string, args ...interface{}) Finding {
+		return Finding{
+			App:       manifestApp.Name,
+			Key:       "docker",
+			Rule:      rule,
+			Severity:  SeverityError,
+			File:      manifestPath,
+			Line:      line,
+			Message:   fmt.Sprintf(format, args...),
+			Attribute: attribute,
+			Expected:  expected,
+			Actual:    actual,
+		}
+	}
+
+	switch {
+	case declared == nil && lifecycle != dockerLifecycle:
+		return nil
+
+	case declared == nil:
+		return []Finding{finding(RuleDockerMode, "image", "", pkg.Data.Image, manifestApp.Lines.Name,
+			"App '%s' runs docker image '%s', the manifest declares a buildpack app", manifestApp.Name, pkg.Data.Image)}
+
+	case lifecycle != dockerLifecycle:
+		return []Finding{finding(RuleDockerMode, "image", declared.Image, "", lines.Line,
+			"App '%s' runs from a buildpack, the manifest declares docker image '%s'", manifestApp.Name, declared.Image)}
+	}
+
+	if difference := imageDifference(ParseImageReference(declared.Image), ParseImageReference(pkg.Data.Image)); difference != "" {
+		f := finding(RuleChangedDockerImage, "image", declared.Image, pkg.Data.Image, lines.Keys["image"],
+			"App '%s' runs docker image '%s', the manifest declares '%s' (%s differs)", manifestApp.Name, pkg.Data.Image, declared.Image, difference)
+		f.Remediation = adoptValue(manifestApp, pkg.Data.Image, "docker", "image")
+		findings = append(findings, f)
+	}
+
+	if declared.Username != pkg.Data.Username {
+		line := lines.Keys["username"]
+		if line == 0 {
+			line = lines.Line
+		}
+
+		f := finding(RuleChangedDockerUsername, "username", declared.Username, pkg.Data.Username, line,
+			"App '%s' pulls its docker image as '%s', the manifest declares '%s'", manifestApp.Name, pkg.Data.Username, declared.Username)
+		f.Remediation = adoptValue(manifestApp, pkg.Data.Username, "docker", "username")
+		if pkg.Data.Username == "" {
+			f.Remediation = adoptRemoval(manifestApp, "docker", "username")
+		}
+		findings = append(findings, f)
+	}
+
+	return findings
+}
+
+// ImageReference is a docker image reference split into its parts, with
+// Docker Hub defaults filled in.
+type ImageReference struct {
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseImageReference splits `registry/repository:tag@digest`. Images
+// without a registry are on Docker Hub, and images without a tag or digest
+// use `latest`.
+func ParseImageReference(image string) ImageReference {
+	var ref ImageReference
+
+	if at := strings.Index(image, "@"); at >= 0 {
+		image, ref.Digest = image[:at], image[at+1:]
+	}
+
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		image, ref.Tag = image[:colon], image[colon+1:]
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	parts := strings.SplitN(image, "/", 2)
+	hasRegistry := len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost")
+
+	switch {
+	case !hasRegistry && len(parts) == 1:
+		image = dockerHub + "/library/" + image
+	case !hasRegistry:
+		image = dockerHub + "/" + image
+	}
+
+	ref.Repository = image
+	return ref
+}
+
+// imageDifference names the part of two image references which differs.
+// Digests identify an image exactly, so matching digests win over tags.
+func imageDifference(declared, actual ImageReference) string {
+	switch {
+	case declared.Repository != actual.Repository:
+		return "repository"
+	case declared.Digest != "" && declared.Digest == actual.Digest:
+		return ""
+	case declared.Digest != "":
+		return "digest"
+	case declared.Tag != actual.Tag:
+		return "tag"
+	}
+	return ""
+}
+
+const (
+	RuleDockerMode            = "docker-mode"
+	RuleChangedDockerImage    = "changed-docker-image"
+	RuleChangedDockerUsername = "changed-docker-username"
+	RuleDocker                = "docker"
+
+	dockerLifecycle = "docker"
+	dockerHub       = "docker.io"
+)
@@ -0,0 +1,114 @@
+package check_test
+
+import (
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compare Docker", func() {
+	var app manifest.YApplication
+	var pkg cc.Package
+
+	BeforeEach(func() {
+		var err error
+		app, err = manifest.LoadApplication("../fixtures/docker-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		pkg = cc.Package{Type: "docker"}
+		pkg.Data.Image = "registry.example.com/payments/api:1.4.2"
+		pkg.Data.Username = "deployer"
+	})
+
+	It("has no findings when the image matches the manifest", func() {
+		Expect(CompareDocker("manifest.yml", app, "docker", pkg)).To(BeEmpty())
+	})
+
+	It("reports a different tag at the image key", func() {
+		pkg.Data.Image = "registry.example.com/payments/api:1.4.3-hotfix"
+
+		findings := CompareDocker("manifest.yml", app, "docker", pkg)
+		Expect(findings).To(Equal([]Finding{{
+			App:       "app-name",
+			Key:       "docker",
+			Rule:      RuleChangedDockerImage,
+			Severity:  SeverityError,
+			File:      "manifest.yml",
+			Line:      5,
+			Message:   "App 'app-name' runs docker image 'registry.example.com/payments/api:1.4.3-hotfix', the manifest declares 'registry.example.com/payments/api:1.4.2' (tag differs)",
+			Attribute: "image",
+			Expected:  "registry.example.com/payments/api:1.4.2",
+			Actual:    "registry.example.com/payments/api:1.4.3-hotfix",
+			Remediation: &Remediation{Ops: []manifest.Op{
+				{Type: manifest.OpReplace, Path: "/applications/name=app-name/docker/image", Value: "registry.example.com/payments/api:1.4.3-hotfix"},
+			}},
+		}}))
+	})
+
+	It("reports a different username", func() {
+		pkg.Data.Username = "jane"
+
+		findings := CompareDocker("manifest.yml", app, "docker", pkg)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RuleChangedDockerUsername))
+		Expect(findings[0].Message).To(Equal("App 'app-name' pulls its docker image as 'jane', the manifest declares 'deployer'"))
+		Expect(findings[0].Line).To(Equal(6))
+	})
+
+	It("reports switches between buildpack and docker apps", func() {
+		findings := CompareDocker("manifest.yml", app, "buildpack", cc.Package{})
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RuleDockerMode))
+		Expect(findings[0].Message).To(Equal("App 'app-name' runs from a buildpack, the manifest declares docker image 'registry.example.com/payments/api:1.4.2'"))
+		Expect(findings[0].Line).To(Equal(4))
+
+		buildpackApp, err := manifest.LoadApplication("../fixtures/manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		findings = CompareDocker("manifest.yml", buildpackApp, "docker", pkg)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Message).To(Equal("App 'app-name' runs docker image 'registry.example.com/payments/api:1.4.2', the manifest declares a buildpack app"))
+		Expect(findings[0].Line).To(Equal(3))
+
+		Expect(CompareDocker("manifest.yml", buildpackApp, "buildpack", cc.Package{})).To(BeEmpty())
+	})
+
+	It("treats Docker Hub defaults as equal", func() {
+		hubApp, err := manifest.LoadApplication("../fixtures/docker-manifest.yml", "hub-app")
+		Expect(err).ToNot(HaveOccurred())
+
+		pkg.Data.Image = "docker.io/library/nginx:latest"
+		pkg.Data.Username = ""
+
+		Expect(CompareDocker("manifest.yml", hubApp, "docker", pkg)).To(BeEmpty())
+	})
+
+	It("compares digests", func() {
+		app.Docker.Image = "registry.example.com/payments/api@sha256:aaaa"
+		pkg.Data.Image = "registry.example.com/payments/api:1.4.2@sha256:bbbb"
+
+		findings := CompareDocker("manifest.yml", app, "docker", pkg)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Message).To(HaveSuffix("(digest differs)"))
+
+		pkg.Data.Image = "registry.example.com/payments/api:1.4.2@sha256:aaaa"
+		Expect(CompareDocker("manifest.yml", app, "docker", pkg)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Parse Image Reference", func() {
+	It("splits registry, tag and digest", func() {
+		Expect(ParseImageReference("localhost:5000/api:1.0@sha256:abc")).To(Equal(ImageReference{
+			Repository: "localhost:5000/api",
+			Tag:        "1.0",
+			Digest:     "sha256:abc",
+		}))
+	})
+
+	It("fills in Docker Hub defaults", func() {
+		Expect(ParseImageReference("nginx")).To(Equal(ImageReference{Repository: "docker.io/library/nginx", Tag: "latest"}))
+		Expect(ParseImageReference("bitnami/redis:7")).To(Equal(ImageReference{Repository: "docker.io/bitnami/redis", Tag: "7"}))
+	})
+})
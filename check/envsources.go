@@ -0,0 +1,180 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+const (
+	EnvSourceUserProvided = "user-provided"
+	EnvSourceRunningGroup = "running-env-group"
+	EnvSourceStagingGroup = "staging-env-group"
+	EnvSourceSystem       = "system"
+)
+
+var envSourceNames = map[string]string{
+	EnvSourceUserProvided: "user-provided env",
+	EnvSourceRunningGroup: "running env group",
+	EnvSourceStagingGroup: "staging env group",
+	EnvSourceSystem:       "system env",
+}
+
+// EnvSources holds an app's ENV vars split by where they come from.
+type EnvSources struct {
+	UserProvided map[string]interface{}
+	RunningGroup map[string]interface{}
+	StagingGroup map[string]interface{}
+	System       map[string]interface{}
+}
+
+// GetEnvSources fetches the app's own ENV vars and system env, and the
+// platform's running and staging environment variable groups.
+func GetEnvSources(client *cc.Client, appGUID string) (EnvSources, error) {
+	env, err := client.GetAppEnvironment(appGUID)
+	if err != nil {
+		return EnvSources{}, err
+	}
+
+	running, staging, err := GetEnvGroups(client)
+	if err != nil {
+		return EnvSources{}, err
+	}
+
+	return EnvSources{
+		UserProvided: env.EnvironmentVariables,
+		RunningGroup: running,
+		StagingGroup: staging,
+		System:       env.SystemEnvJSON,
+	}, nil
+}
+
+// GetEnvGroups fetches the platform's running and staging environment
+// variable groups, which are the same for every app.
+func GetEnvGroups(client *cc.Client) (running, staging map[string]interface{}, err error) {
+	running, err = client.GetEnvironmentVariableGroup("running")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	staging, err = client.GetEnvironmentVariableGroup("staging")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return running, staging, nil
+}
+
+// SourceOf returns where an ENV var comes from. The app's own ENV vars take
+// precedence over the groups, as they do when the app runs.
+func (s EnvSources) SourceOf(key string) string {
+	switch {
+	case hasKey(s.UserProvided, key):
+		return EnvSourceUserProvided
+	case hasKey(s.System, key) || strings.HasPrefix(key, "VCAP_"):
+		return EnvSourceSystem
+	case hasKey(s.RunningGroup, key):
+		return EnvSourceRunningGroup
+	case hasKey(s.StagingGroup, key):
+		return EnvSourceStagingGroup
+	}
+	return ""
+}
+
+// AttributeEnvSources records the source of each ENV finding.
+func AttributeEnvSources(findings []Finding, sources EnvSources) []Finding {
+	for i, f := range findings {
+		if isEnvRule(f.Rule) {
+			findings[i].Source = sources.SourceOf(f.Key)
+		}
+	}
+
+	return findings
+}
+
+// CompareEnvGroups finds the ENV vars which the running and staging env
+// groups give an app on top of its own, making its effective env differ
+// from what the manifest implies. The app's own ENV vars override the
+// groups, so only keys it doesn't set itself are compared. Group vars the
+// manifest doesn't declare are platform-managed, and reported as warnings
+// rather than asking for them to be added; group vars which override a
+// value the manifest declares are reported as drift.
+func CompareEnvGroups(manifestPath string, manifestApp manifest.YApplication, sources EnvSources) (findings []Finding) {
+	groups := []struct {
+		source string
+		env    map[string]interface{}
+	}{
+		{EnvSourceRunningGroup, sources.RunningGroup},
+		{EnvSourceStagingGroup, sources.StagingGroup},
+	}
+
+	seen := map[string]bool{}
+
+	for _, group := range groups {
+		var keys []string
+		for k := range group.env {
+			if !hasKey(sources.UserProvided, k) && !seen[k] {
+				keys = append(keys, k)
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			seen[k] = true
+			name := envSourceNames[group.source]
+
+			manifestValue, declared := manifestApp.Env[k]
+			if !declared {
+				findings = append(findings, Finding{
+					App:      manifestApp.Name,
+					Key:      k,
+					Rule:     RulePlatformEnv,
+					Severity: SeverityWarning,
+					File:     manifestPath,
+					Line:     blockLine(manifestApp.Lines.Env, manifestApp.Lines),
+					Message:  fmt.Sprintf("App '%s' has ENV var '%s' from the %s, which is managed by the platform and doesn't belong in the manifest", manifestApp.Name, k, name),
+					Source:   group.source,
+				})
+				continue
+			}
+
+			if _, pinned := ParsePin(manifestValue); pinned || envValuesMatch(manifestValue, group.env[k]) {
+				continue
+			}
+
+			findings = append(findings, Finding{
+				App:      manifestApp.Name,
+				Key:      k,
+				Rule:     RuleChangedPlatformEnv,
+				Severity: SeverityError,
+				File:     manifestPath,
+				Line:     manifestApp.Lines.EnvKeys[k],
+				Message:  fmt.Sprintf("App '%s' has ENV var '%s' from the %s with a value different from the manifest", manifestApp.Name, k, name),
+				Source:   group.source,
+
+				Remediation: &Remediation{Command: cfCommand("set-env", manifestApp.Name, k, manifest.EnvValueString(manifestValue))},
+			})
+		}
+	}
+
+	return findings
+}
+
+func isEnvRule(rule string) bool {
+	return rule == RuleUnexpectedEnv || rule == RuleChangedEnv || rule == RuleTypoEnv
+}
+
+func hasKey(m map[string]interface{}, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+const (
+	RulePlatformEnv        = "platform-env"
+	RuleChangedPlatformEnv = "changed-platform-env"
+	RuleEnvSources         = "env-sources"
+)
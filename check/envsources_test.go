@@ -0,0 +1,118 @@
+package check_test
+
+import (
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Env Sources", func() {
+	var sources EnvSources
+
+	BeforeEach(func() {
+		sources = EnvSources{
+			UserProvided: map[string]interface{}{"DATABASE_URL": "postgres://db", "LOG_LEVEL": "debug"},
+			RunningGroup: map[string]interface{}{"LOG_LEVEL": "info", "HTTP_PROXY": "http://proxy"},
+			StagingGroup: map[string]interface{}{"BP_DEBUG": "true"},
+			System:       map[string]interface{}{"VCAP_SERVICES": map[string]interface{}{}},
+		}
+	})
+
+	It("knows where each ENV var comes from", func() {
+		Expect(sources.SourceOf("DATABASE_URL")).To(Equal(EnvSourceUserProvided))
+		Expect(sources.SourceOf("LOG_LEVEL")).To(Equal(EnvSourceUserProvided))
+		Expect(sources.SourceOf("HTTP_PROXY")).To(Equal(EnvSourceRunningGroup))
+		Expect(sources.SourceOf("BP_DEBUG")).To(Equal(EnvSourceStagingGroup))
+		Expect(sources.SourceOf("VCAP_SERVICES")).To(Equal(EnvSourceSystem))
+		Expect(sources.SourceOf("UNKNOWN")).To(BeEmpty())
+	})
+
+	It("records the source of ENV findings", func() {
+		findings := AttributeEnvSources([]Finding{
+			{App: "app-name", Key: "DATABASE_URL", Rule: RuleUnexpectedEnv, Severity: SeverityError},
+		}, sources)
+
+		Expect(findings[0].Rule).To(Equal(RuleUnexpectedEnv))
+		Expect(findings[0].Severity).To(Equal(SeverityError))
+		Expect(findings[0].Source).To(Equal(EnvSourceUserProvided))
+	})
+
+	It("warns about group vars the manifest doesn't declare", func() {
+		findings := CompareEnvGroups("manifest.yml", manifest.YApplication{
+			Name:  "app-name",
+			Env:   map[string]interface{}{"DATABASE_URL": "postgres://db", "LOG_LEVEL": "debug"},
+			Lines: manifest.YLines{Name: 3, Env: 5},
+		}, sources)
+
+		Expect(findings).To(Equal([]Finding{
+			{
+				App:      "app-name",
+				Key:      "HTTP_PROXY",
+				Rule:     RulePlatformEnv,
+				Severity: SeverityWarning,
+				File:     "manifest.yml",
+				Line:     5,
+				Message:  "App 'app-name' has ENV var 'HTTP_PROXY' from the running env group, which is managed by the platform and doesn't belong in the manifest",
+				Source:   EnvSourceRunningGroup,
+			},
+			{
+				App:      "app-name",
+				Key:      "BP_DEBUG",
+				Rule:     RulePlatformEnv,
+				Severity: SeverityWarning,
+				File:     "manifest.yml",
+				Line:     5,
+				Message:  "App 'app-name' has ENV var 'BP_DEBUG' from the staging env group, which is managed by the platform and doesn't belong in the manifest",
+				Source:   EnvSourceStagingGroup,
+			},
+		}))
+	})
+
+	It("reports group vars which override the manifest's value", func() {
+		findings := CompareEnvGroups("manifest.yml", manifest.YApplication{
+			Name:  "app-name",
+			Env:   map[string]interface{}{"HTTP_PROXY": "http://other-proxy", "BP_DEBUG": true},
+			Lines: manifest.YLines{Name: 3, Env: 5, EnvKeys: map[string]int{"HTTP_PROXY": 6, "BP_DEBUG": 7}},
+		}, sources)
+
+		Expect(findings).To(Equal([]Finding{{
+			App:      "app-name",
+			Key:      "HTTP_PROXY",
+			Rule:     RuleChangedPlatformEnv,
+			Severity: SeverityError,
+			File:     "manifest.yml",
+			Line:     6,
+			Message:  "App 'app-name' has ENV var 'HTTP_PROXY' from the running env group with a value different from the manifest",
+			Source:   EnvSourceRunningGroup,
+
+			Remediation: &Remediation{Command: "cf set-env app-name HTTP_PROXY http://other-proxy"},
+		}}))
+	})
+
+	It("ignores group vars the app overrides itself", func() {
+		findings := CompareEnvGroups("manifest.yml", manifest.YApplication{
+			Name: "app-name",
+			Env:  map[string]interface{}{"HTTP_PROXY": "http://proxy", "BP_DEBUG": "true"},
+		}, sources)
+
+		Expect(findings).To(BeEmpty())
+	})
+
+	It("notes the source of changed values", func() {
+		findings := AttributeEnvSources([]Finding{
+			{App: "app-name", Key: "HTTP_PROXY", Rule: RuleChangedEnv, Severity: SeverityError, Message: "changed"},
+		}, sources)
+
+		Expect(findings[0].Severity).To(Equal(SeverityError))
+		Expect(findings[0].Description()).To(Equal("changed (from running env group)"))
+	})
+
+	It("leaves other findings alone", func() {
+		findings := AttributeEnvSources([]Finding{
+			{Key: "HTTP_PROXY", Rule: RuleUnexpectedService},
+		}, sources)
+
+		Expect(findings[0].Source).To(BeEmpty())
+	})
+})
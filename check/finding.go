@@ -0,0 +1,107 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/odlp/antifreeze/git"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Finding is a single problem reported against a manifest, located by file
+// and line so editors and CI systems can point straight at it.
+type Finding struct {
+	App         string
+	Key         string
+	ManifestKey string
+	Rule        string
+	Severity    string
+	File        string
+	Line        int
+	Message     string
+
+	// Attribute, Expected and Actual describe a changed attribute of Key,
+	// such as a process's instances, as declared and as found.
+	Attribute string
+	Expected  string
+	Actual    string
+
+	// Actor and ChangedAt attribute the finding to the most recent matching
+	// change recorded in the Cloud Controller's audit events.
+	Actor     string
+	ChangedAt time.Time
+
+	// Source is where an ENV var's value comes from, such as
+	// EnvSourceUserProvided or EnvSourceRunningGroup.
+	Source string
+
+	// Remediation suggests how to resolve the finding, when there's a fix
+	// to suggest.
+	Remediation *Remediation
+
+	// Commit is the commit which last changed the finding's manifest line,
+	// when findings are blamed.
+	Commit *git.Commit
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", f.File, f.Line, f.Severity, f.Description())
+}
+
+// Description is the message followed by its notes, such as who last made
+// a matching change, when there are any.
+func (f Finding) Description() string {
+	if notes := f.Notes(); len(notes) > 0 {
+		return fmt.Sprintf("%s (%s)", f.Message, strings.Join(notes, ", "))
+	}
+	return f.Message
+}
+
+// Notes qualify a finding with where its value came from and who changed
+// it, when those are known.
+func (f Finding) Notes() (notes []string) {
+	if f.Source != "" {
+		notes = append(notes, "from "+envSourceNames[f.Source])
+	}
+
+	if attribution := f.Attribution(); attribution != "" {
+		notes = append(notes, attribution)
+	}
+
+	if f.Commit != nil {
+		notes = append(notes, commitNote(*f.Commit))
+	}
+
+	return notes
+}
+
+func (f Finding) Attribution() string {
+	if f.Actor == "" {
+		return ""
+	}
+	return fmt.Sprintf("last changed by %s at %s", f.Actor, f.ChangedAt.UTC().Format(time.RFC3339))
+}
+
+// zeroHash is the hash git blame gives lines which aren't committed yet.
+const zeroHash = "0000000000000000000000000000000000000000"
+
+func commitNote(commit git.Commit) string {
+	if commit.Hash == zeroHash {
+		return "manifest line not committed yet"
+	}
+	return fmt.Sprintf("manifest line last changed in %s by %s: %s", git.ShortHash(commit.Hash), commit.Author, commit.Summary)
+}
+
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,397 @@
+package check
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/odlp/antifreeze/manifest"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// LintManifest checks a manifest for mistakes which can be found without
+// connecting to Cloud Foundry.
+func LintManifest(manifestPath string) ([]Finding, error) {
+	return lintManifest(manifest.Read, manifestPath)
+}
+
+func lintManifest(read manifest.ReadFunc, manifestPath string) ([]Finding, error) {
+	document, err := manifest.LoadMergedWith(read, []string{manifestPath}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{path: manifestPath, dir: filepath.Dir(manifestPath)}
+	l.lintDocument(document.Node)
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		return l.findings[i].Line < l.findings[j].Line
+	})
+
+	return l.findings, nil
+}
+
+// LintForCheck runs the lint rules which also apply to check-manifest,
+// limited to the named application and the manifest-wide defaults. No name
+// selects every application.
+func LintForCheck(manifestPath, appName string) ([]Finding, error) {
+	return lintForCheck(manifest.Read, manifestPath, appName)
+}
+
+func lintForCheck(read manifest.ReadFunc, manifestPath, appName string) ([]Finding, error) {
+	findings, err := lintManifest(read, manifestPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []Finding
+	for _, f := range findings {
+		if stringInSlice(f.Rule, checkRules) && (appName == "" || f.App == "" || f.App == appName) {
+			selected = append(selected, f)
+		}
+	}
+
+	return selected, nil
+}
+
+type linter struct {
+	path     string
+	dir      string
+	app      string
+	findings []Finding
+}
+
+func (l *linter) add(node *yaml3.Node, severity, rule, format string, args ...interface{}) {
+	l.findings = append(l.findings, Finding{
+		App:      l.app,
+		Rule:     rule,
+		Severity: severity,
+		File:     l.path,
+		Line:     node.Line,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) lintDocument(root *yaml3.Node) {
+	if root.Kind != yaml3.DocumentNode || len(root.Content) == 0 {
+		return
+	}
+
+	l.lintDuplicateKeys(root.Content[0])
+
+	document := manifest.ResolveAlias(root.Content[0])
+	if document.Kind != yaml3.MappingNode {
+		l.add(document, SeverityError, "manifest-structure", "manifest must be a mapping with an 'applications' key")
+		return
+	}
+
+	l.lintKeys(document, manifestKeys)
+	l.lintAttributes(document)
+
+	applications := manifest.MappingValue(document, "applications")
+	if applications == nil {
+		return
+	}
+
+	if applications.Kind != yaml3.SequenceNode {
+		l.add(applications, SeverityError, "manifest-structure", "'applications' must be a list")
+		return
+	}
+
+	appLines := map[string]int{}
+
+	for _, app := range applications.Content {
+		app = manifest.ResolveAlias(app)
+		if app.Kind != yaml3.MappingNode {
+			l.add(app, SeverityError, "manifest-structure", "each application must be a mapping")
+			continue
+		}
+
+		name := manifest.MappingValue(app, "name")
+		if name != nil && name.Kind == yaml3.ScalarNode {
+			l.app = name.Value
+		}
+
+		l.lintKeys(app, applicationKeys)
+		l.lintAttributes(app)
+		l.app = ""
+
+		if name == nil || name.Kind != yaml3.ScalarNode {
+			continue
+		}
+
+		if line, ok := appLines[name.Value]; ok {
+			l.add(name, SeverityError, "duplicate-app", "duplicate application name '%s' (first defined on line %d)", name.Value, line)
+			continue
+		}
+		appLines[name.Value] = name.Line
+	}
+}
+
+func (l *linter) lintDuplicateKeys(node *yaml3.Node) {
+	if node.Kind == yaml3.MappingNode {
+		keyLines := map[string]int{}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Value == mergeKey {
+				continue
+			}
+
+			if line, ok := keyLines[key.Value]; ok {
+				l.add(key, SeverityError, "duplicate-key", "duplicate key '%s' (first defined on line %d)", key.Value, line)
+				continue
+			}
+			keyLines[key.Value] = key.Line
+		}
+	}
+
+	for _, child := range node.Content {
+		l.lintDuplicateKeys(child)
+	}
+}
+
+func (l *linter) lintKeys(mapping *yaml3.Node, known []string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i]
+		if key.Value == mergeKey || stringInSlice(key.Value, known) {
+			continue
+		}
+
+		if suggestion := closestString(key.Value, known); suggestion != "" {
+			l.add(key, SeverityError, "unknown-key", "unknown key '%s', did you mean '%s'?", key.Value, suggestion)
+			continue
+		}
+		l.add(key, SeverityError, "unknown-key", "unknown key '%s'", key.Value)
+	}
+}
+
+func (l *linter) lintAttributes(mapping *yaml3.Node) {
+	for _, key := range []string{"memory", "disk_quota"} {
+		if value := manifest.MappingValue(mapping, key); value != nil {
+			l.lintSize(key, value)
+		}
+	}
+
+	if env := manifest.MappingValue(mapping, "env"); env != nil {
+		l.lintEnv(env)
+	}
+
+	if path := manifest.MappingValue(mapping, "path"); path != nil {
+		l.lintPath(path)
+	}
+
+	for _, list := range []string{"processes", "sidecars"} {
+		items := manifest.MappingValue(mapping, list)
+		if items == nil || items.Kind != yaml3.SequenceNode {
+			continue
+		}
+
+		for _, item := range items.Content {
+			for _, key := range []string{"memory", "disk_quota"} {
+				if value := manifest.MappingValue(manifest.ResolveAlias(item), key); value != nil {
+					l.lintSize(key, value)
+				}
+			}
+		}
+	}
+}
+
+func (l *linter) lintSize(key string, value *yaml3.Node) {
+	if value.Kind == yaml3.ScalarNode && sizePattern.MatchString(value.Value) {
+		return
+	}
+
+	if value.Kind == yaml3.ScalarNode && digitsPattern.MatchString(value.Value) {
+		l.add(value, SeverityError, "malformed-size", "%s '%s' is missing a unit, e.g. %sM", key, value.Value, value.Value)
+		return
+	}
+
+	l.add(value, SeverityError, "malformed-size", "%s '%s' is not a valid size, e.g. 256M or 1G", key, value.Value)
+}
+
+func (l *linter) lintEnv(env *yaml3.Node) {
+	if env.Kind != yaml3.MappingNode {
+		l.add(env, SeverityError, "env-structure", "'env' must be a mapping of names to values")
+		return
+	}
+
+	for i := 0; i+1 < len(env.Content); i += 2 {
+		key, value := env.Content[i], manifest.ResolveAlias(env.Content[i+1])
+
+		if value.Kind != yaml3.ScalarNode {
+			l.add(value, SeverityError, "env-not-scalar", "ENV var '%s' must be a string, number or boolean", key.Value)
+			continue
+		}
+
+		l.lintCoercion(key, value)
+	}
+}
+
+// lintCoercion warns when an unquoted ENV value is decoded into something
+// other than what was written, e.g. `yes` becoming true or `0755` becoming
+// 493. The cf CLI sends the decoded value, not the text in the manifest.
+func (l *linter) lintCoercion(key, value *yaml3.Node) {
+	if value.Style != 0 {
+		return
+	}
+
+	var decoded interface{}
+	if err := yaml.Unmarshal([]byte(value.Value), &decoded); err != nil {
+		return
+	}
+
+	if manifest.EnvValueString(decoded) == value.Value {
+		return
+	}
+
+	if decoded == nil {
+		l.add(value, SeverityWarning, "yaml-coercion", "ENV var '%s' is written as %s but decoded as null; quote the value to keep it as written", key.Value, value.Value)
+		return
+	}
+
+	l.add(value, SeverityWarning, "yaml-coercion", "ENV var '%s' is written as %s but decoded as %s (%T); quote the value to keep it as written", key.Value, value.Value, manifest.EnvValueString(decoded), decoded)
+}
+
+func (l *linter) lintPath(path *yaml3.Node) {
+	if path.Kind != yaml3.ScalarNode {
+		l.add(path, SeverityError, "missing-path", "'path' must be a string")
+		return
+	}
+
+	target := path.Value
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(l.dir, target)
+	}
+
+	if _, err := os.Stat(target); err != nil {
+		l.add(path, SeverityError, "missing-path", "path '%s' does not exist", path.Value)
+	}
+}
+
+// closestString returns the candidate which s is most likely a typo of, or
+// an empty string when nothing is close enough.
+func closestString(s string, candidates []string) string {
+	best, bestDistance := "", maxTypoDistance+1
+
+	for _, c := range candidates {
+		if d := editDistance(s, c); d < bestDistance && isLikelyTypo(s, c, d) {
+			best, bestDistance = c, d
+		}
+	}
+
+	return best
+}
+
+// isLikelyTypo decides whether two strings a distance d apart are close
+// enough to be the same word mistyped. Short words need to be closer, so
+// `DB` and `ID` aren't paired up.
+func isLikelyTypo(a, b string, d int) bool {
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	return d > 0 && d <= maxTypoDistance && d*4 <= longest
+}
+
+// editDistance is the number of insertions, deletions, substitutions and
+// swaps of adjacent characters needed to turn a into b.
+func editDistance(a, b string) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			rows[i][j] = minInt(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				rows[i][j] = minInt(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return rows[len(a)][len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+const (
+	mergeKey        = "<<"
+	maxTypoDistance = 2
+)
+
+var (
+	sizePattern   = regexp.MustCompile(`(?i)^\d+(\.\d+)?\s*(B|K|KB|M|MB|G|GB|T|TB)$`)
+	digitsPattern = regexp.MustCompile(`^\d+$`)
+)
+
+// checkRules are the lint rules which also run as part of check-manifest.
+var checkRules = []string{"yaml-coercion"}
+
+// applicationKeys are the app attributes in the cf app manifest attribute
+// reference, including those the cf CLI still accepts but has deprecated.
+var applicationKeys = []string{
+	"buildpack",
+	"buildpacks",
+	"command",
+	"default-route",
+	"disk_quota",
+	"docker",
+	"domain",
+	"domains",
+	"env",
+	"health-check-http-endpoint",
+	"health-check-interval",
+	"health-check-invocation-timeout",
+	"health-check-type",
+	"host",
+	"hosts",
+	"instances",
+	"lifecycle",
+	"log-rate-limit-per-second",
+	"memory",
+	"metadata",
+	"name",
+	"no-hostname",
+	"no-route",
+	"path",
+	"processes",
+	"random-route",
+	"readiness-health-check-http-endpoint",
+	"readiness-health-check-interval",
+	"readiness-health-check-invocation-timeout",
+	"readiness-health-check-type",
+	"routes",
+	"services",
+	"sidecars",
+	"stack",
+	"timeout",
+}
+
+// manifestKeys are valid at the top of a manifest. Application attributes
+// are allowed there too, as defaults shared by every application.
+var manifestKeys = append([]string{"applications", "inherit", "version"}, applicationKeys...)
@@ -0,0 +1,128 @@
+package check_test
+
+import (
+	. "github.com/odlp/antifreeze/check"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lint Manifest", func() {
+	var findings []Finding
+
+	BeforeEach(func() {
+		var err error
+		findings, err = LintManifest("../fixtures/lint-manifest.yml")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	findingFor := func(rule string, line int) Finding {
+		for _, f := range findings {
+			if f.Rule == rule && f.Line == line {
+				return f
+			}
+		}
+		Fail("no finding for rule " + rule)
+		return Finding{}
+	}
+
+	It("flags sizes without a unit", func() {
+		f := findingFor("malformed-size", 4)
+		Expect(f.Message).To(Equal("memory '256' is missing a unit, e.g. 256M"))
+		Expect(f.File).To(Equal("../fixtures/lint-manifest.yml"))
+	})
+
+	It("flags duplicate YAML keys", func() {
+		f := findingFor("duplicate-key", 9)
+		Expect(f.Message).To(Equal("duplicate key 'ENV_VAR_1' (first defined on line 8)"))
+	})
+
+	It("flags ENV values which aren't scalars", func() {
+		f := findingFor("env-not-scalar", 11)
+		Expect(f.Message).To(Equal("ENV var 'ENV_VAR_2' must be a string, number or boolean"))
+	})
+
+	It("flags duplicate application names", func() {
+		f := findingFor("duplicate-app", 12)
+		Expect(f.Message).To(Equal("duplicate application name 'app-1' (first defined on line 3)"))
+	})
+
+	It("suggests a key for misspelled keys", func() {
+		f := findingFor("unknown-key", 13)
+		Expect(f.Message).To(Equal("unknown key 'memroy', did you mean 'memory'?"))
+	})
+
+	It("flags unknown keys", func() {
+		f := findingFor("unknown-key", 17)
+		Expect(f.Message).To(Equal("unknown key 'colour'"))
+	})
+
+	It("flags paths which don't exist", func() {
+		f := findingFor("missing-path", 14)
+		Expect(f.Message).To(Equal("path './pure-fiction' does not exist"))
+	})
+
+	It("reports findings in line order", func() {
+		Expect(findings).To(HaveLen(7))
+		Expect(findings[0].Line).To(Equal(4))
+		Expect(findings[6].Line).To(Equal(17))
+		Expect(HasErrors(findings)).To(BeTrue())
+	})
+
+	Context("valid manifest", func() {
+		It("has no findings", func() {
+			findings, err := LintManifest("../fixtures/multi-manifest.yml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(findings).To(BeEmpty())
+		})
+
+		It("accepts every app attribute cf supports", func() {
+			findings, err := LintManifest("../fixtures/full-manifest.yml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(findings).To(BeEmpty())
+		})
+	})
+
+	Context("invalid manifest path", func() {
+		It("returns an error", func() {
+			_, err := LintManifest("./pure-fiction")
+			Expect(err).To(MatchError("Unable to read manifest file: ./pure-fiction"))
+		})
+	})
+})
+
+var _ = Describe("YAML Coercion", func() {
+	It("warns about values which aren't decoded as written", func() {
+		findings, err := LintManifest("../fixtures/coercion-manifest.yml")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(HasErrors(findings)).To(BeFalse())
+
+		var messages []string
+		for _, f := range findings {
+			Expect(f.Rule).To(Equal("yaml-coercion"))
+			Expect(f.Severity).To(Equal(SeverityWarning))
+			messages = append(messages, f.Message)
+		}
+
+		Expect(messages).To(Equal([]string{
+			"ENV var 'FEATURE_ENABLED' is written as yes but decoded as true (bool); quote the value to keep it as written",
+			"ENV var 'FILE_MODE' is written as 0755 but decoded as 493 (int); quote the value to keep it as written",
+			"ENV var 'RETRIES' is written as 1e3 but decoded as 1000 (float64); quote the value to keep it as written",
+			"ENV var 'NOTHING' is written as ~ but decoded as null; quote the value to keep it as written",
+			"ENV var 'VERBOSE' is written as on but decoded as true (bool); quote the value to keep it as written",
+		}))
+	})
+
+	It("limits check mode warnings to the checked app", func() {
+		findings, err := LintForCheck("../fixtures/coercion-manifest.yml", "app-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].App).To(Equal("app-2"))
+		Expect(findings[0].Line).To(Equal(16))
+	})
+
+	It("skips other lint rules in check mode", func() {
+		findings, err := LintForCheck("../fixtures/lint-manifest.yml", "app-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(findings).To(BeEmpty())
+	})
+})
@@ -0,0 +1,118 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+// DefaultIgnoredMetadataPrefixes are prefixes of labels and annotations the
+// platform manages itself. Subdomains are ignored too, so
+// `app.cloudfoundry.org/...` is left out.
+var DefaultIgnoredMetadataPrefixes = []string{"cloudfoundry.org"}
+
+// CompareMetadata reports labels and annotations which were added, removed
+// or changed outside the manifest, leaving out keys with ignored prefixes.
+func CompareMetadata(manifestPath string, manifestApp manifest.YApplication, metadata cc.Metadata, ignorePrefixes []string) (findings []Finding) {
+	ignored := append(append([]string{}, DefaultIgnoredMetadataPrefixes...), ignorePrefixes...)
+
+	findings = append(findings, compareMetadataKind(manifestPath, manifestApp, "label", manifestApp.Metadata.Labels, metadata.Labels, manifestApp.Lines.Labels, ignored)...)
+	findings = append(findings, compareMetadataKind(manifestPath, manifestApp, "annotation", manifestApp.Metadata.Annotations, metadata.Annotations, manifestApp.Lines.Annotations, ignored)...)
+
+	return findings
+}
+
+func compareMetadataKind(manifestPath string, manifestApp manifest.YApplication, kind string, declared map[string]string, live map[string]*string, lines manifest.YItemLines, ignored []string) (findings []Finding) {
+	finding := func(rule, key, format string, args ...interface{}) Finding {
+		return Finding{
+			App:      manifestApp.Name,
+			Key:      key,
+			Rule:     rule,
+			Severity: SeverityError,
+			File:     manifestPath,
+			Message:  fmt.Sprintf(format, args...),
+		}
+	}
+
+	var keys []string
+	for k := range declared {
+		keys = append(keys, k)
+	}
+	for k := range live {
+		if _, ok := declared[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if isIgnoredMetadataKey(k, ignored) {
+			continue
+		}
+
+		expected, isDeclared := declared[k]
+		actual, isLive := live[k]
+		if isLive && actual == nil {
+			isLive = false
+		}
+
+		switch {
+		case isDeclared && !isLive:
+			f := finding("missing-"+kind, k, "App '%s' has no %s '%s', which the manifest declares", manifestApp.Name, kind, k)
+			f.Line = lines.Keys[k]
+			f.Expected = expected
+			f.Remediation = adoptRemoval(manifestApp, "metadata", kind+"s", k)
+			findings = append(findings, f)
+
+		case !isDeclared && !isLive:
+			// A null value is a key which has been deleted.
+
+		case !isDeclared:
+			f := finding("unexpected-"+kind, k, "App '%s' has unexpected %s '%s' (missing from manifest)", manifestApp.Name, kind, k)
+			f.Line = blockLine(lines.Line, manifestApp.Lines)
+			f.Actual = *actual
+			f.Remediation = adoptValue(manifestApp, *actual, "metadata?", kind+"s", k)
+			findings = append(findings, f)
+
+		case expected != *actual:
+			f := finding("changed-"+kind, k, "App '%s' has %s '%s' set to '%s', the manifest declares '%s'", manifestApp.Name, kind, k, *actual, expected)
+			f.Line = lines.Keys[k]
+			f.Expected = expected
+			f.Actual = *actual
+			f.Remediation = adoptValue(manifestApp, *actual, "metadata", kind+"s", k)
+			findings = append(findings, f)
+		}
+	}
+
+	return findings
+}
+
+// isIgnoredMetadataKey checks a key's prefix, the part before the slash in
+// `prefix/name`, against the ignored prefixes and their subdomains.
+func isIgnoredMetadataKey(key string, ignored []string) bool {
+	slash := strings.LastIndex(key, "/")
+	if slash < 0 {
+		return false
+	}
+
+	prefix := key[:slash]
+	for _, p := range ignored {
+		if prefix == p || strings.HasSuffix(prefix, "."+p) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	RuleUnexpectedLabel      = "unexpected-label"
+	RuleChangedLabel         = "changed-label"
+	RuleMissingLabel         = "missing-label"
+	RuleUnexpectedAnnotation = "unexpected-annotation"
+	RuleChangedAnnotation    = "changed-annotation"
+	RuleMissingAnnotation    = "missing-annotation"
+	RuleMetadata             = "metadata"
+)
@@ -0,0 +1,100 @@
+package check_test
+
+import (
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compare Metadata", func() {
+	var metadata cc.Metadata
+	var app manifest.YApplication
+
+	stringPointer := func(s string) *string {
+		return &s
+	}
+
+	BeforeEach(func() {
+		metadata = cc.Metadata{
+			Labels: map[string]*string{
+				"team":        stringPointer("payments"),
+				"cost-centre": stringPointer("4200"),
+			},
+			Annotations: map[string]*string{
+				"contact": stringPointer("payments@example.com"),
+			},
+		}
+
+		var err error
+		app, err = manifest.LoadApplication("../fixtures/metadata-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("has no findings when metadata matches the manifest", func() {
+		Expect(CompareMetadata("manifest.yml", app, metadata, nil)).To(BeEmpty())
+	})
+
+	It("reports changed values at the offending key", func() {
+		metadata.Labels["team"] = stringPointer("billing")
+
+		findings := CompareMetadata("manifest.yml", app, metadata, nil)
+		Expect(findings).To(Equal([]Finding{{
+			App:      "app-name",
+			Key:      "team",
+			Rule:     RuleChangedLabel,
+			Severity: SeverityError,
+			File:     "manifest.yml",
+			Line:     6,
+			Message:  "App 'app-name' has label 'team' set to 'billing', the manifest declares 'payments'",
+			Expected: "payments",
+			Actual:   "billing",
+			Remediation: &Remediation{Ops: []manifest.Op{
+				{Type: manifest.OpReplace, Path: "/applications/name=app-name/metadata/labels/team", Value: "billing"},
+			}},
+		}}))
+	})
+
+	It("reports added and removed keys", func() {
+		delete(metadata.Labels, "cost-centre")
+		metadata.Annotations["runbook"] = stringPointer("https://wiki.example.com/payments")
+
+		findings := CompareMetadata("manifest.yml", app, metadata, nil)
+		Expect(findings).To(HaveLen(2))
+
+		Expect(findings[0].Rule).To(Equal(RuleMissingLabel))
+		Expect(findings[0].Message).To(Equal("App 'app-name' has no label 'cost-centre', which the manifest declares"))
+		Expect(findings[0].Line).To(Equal(7))
+
+		Expect(findings[1].Rule).To(Equal(RuleUnexpectedAnnotation))
+		Expect(findings[1].Message).To(Equal("App 'app-name' has unexpected annotation 'runbook' (missing from manifest)"))
+		Expect(findings[1].Line).To(Equal(8))
+	})
+
+	It("treats keys with a null value as deleted", func() {
+		metadata.Labels["cost-centre"] = nil
+		metadata.Annotations["runbook"] = nil
+
+		findings := CompareMetadata("manifest.yml", app, metadata, nil)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RuleMissingLabel))
+		Expect(findings[0].Key).To(Equal("cost-centre"))
+	})
+
+	It("ignores system-prefixed keys", func() {
+		metadata.Labels["app.cloudfoundry.org/app-revision"] = stringPointer("3")
+		metadata.Annotations["cloudfoundry.org/managed"] = stringPointer("true")
+		metadata.Annotations["autoscaler.example.com/policy"] = stringPointer("default")
+
+		Expect(CompareMetadata("manifest.yml", app, metadata, []string{"example.com"})).To(BeEmpty())
+	})
+
+	It("doesn't ignore keys which only resemble an ignored prefix", func() {
+		metadata.Labels["notcloudfoundry.org/owner"] = stringPointer("jane")
+
+		findings := CompareMetadata("manifest.yml", app, metadata, nil)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Key).To(Equal("notcloudfoundry.org/owner"))
+	})
+})
@@ -0,0 +1,297 @@
+package check
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/manifest"
+	"github.com/odlp/antifreeze/source"
+)
+
+// checkManifests checks every app in the manifest. Every app in the
+// targeted space is fetched at once, along with what the apps share, then
+// apps are checked by a pool of options.Concurrency workers, and their
+// findings are reported in manifest order whichever finishes first. The
+// audit events of every app with errors are looked up together at the end.
+func checkManifests(connection plugin.CliConnection, options Options) ([]Finding, error) {
+	document, err := manifest.LoadMergedWith(options.read(), options.manifestPaths(), options.OpsPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(document.Applications) == 0 {
+		return nil, fmt.Errorf("No application found in manifest")
+	}
+
+	space, err := connection.GetCurrentSpace()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get current space: %s", err)
+	}
+
+	client := newClient(connection, options)
+
+	states, err := source.FetchSpace(client, space.Guid)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get apps in space '%s': %s", space.Name, err)
+	}
+
+	shared := &spaceState{space: space}
+	shared.runningGroup, shared.stagingGroup, shared.envGroupsErr = GetEnvGroups(client)
+
+	results := make([][]Finding, len(document.Applications))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < maxInt(options.Concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				manifestApp := document.Applications[i]
+				state, ok := states[manifestApp.Name]
+				if !ok {
+					results[i] = []Finding{uncheckedApp(options, manifestApp, fmt.Errorf("Unable to get app '%s': not found in space '%s'", manifestApp.Name, space.Name))}
+					continue
+				}
+				results[i] = checkManifestApp(connection, options, manifestApp, state, shared)
+			}
+		}()
+	}
+
+	for i := range document.Applications {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var erroring []string
+	for i, manifestApp := range document.Applications {
+		if state, ok := states[manifestApp.Name]; ok && HasErrors(results[i]) {
+			erroring = append(erroring, state.Model.Guid)
+		}
+	}
+
+	if len(erroring) > 0 {
+		events, err := GetSpaceAuditEvents(client, space.Guid, erroring, options.Since)
+		for i, manifestApp := range document.Applications {
+			if state, ok := states[manifestApp.Name]; ok && HasErrors(results[i]) {
+				results[i] = annotateApp(options, manifestApp, state.Model, results[i], events[state.Model.Guid], err)
+			}
+		}
+	}
+
+	var findings []Finding
+	for _, result := range results {
+		findings = append(findings, result...)
+	}
+
+	return locateFindings(document, options, findings), nil
+}
+
+// checkManifestApp checks one of several apps, reporting an app which
+// couldn't be checked as an error rather than abandoning the others.
+func checkManifestApp(cliConnection plugin.CliConnection, options Options, manifestApp manifest.YApplication, state source.AppState, space *spaceState) []Finding {
+	options.AppName = manifestApp.Name
+
+	companions, err := loadCompanions(options)
+	if err != nil {
+		return []Finding{uncheckedApp(options, manifestApp, err)}
+	}
+
+	return checkAppState(cliConnection, options, manifestApp, companions, state, space)
+}
+
+// spaceState is what checkManifests fetches once for every app it checks,
+// rather than for each app.
+type spaceState struct {
+	space                      plugin_models.Space
+	runningGroup, stagingGroup map[string]interface{}
+	envGroupsErr               error
+}
+
+func uncheckedApp(options Options, manifestApp manifest.YApplication, err error) Finding {
+	return Finding{
+		App:      manifestApp.Name,
+		Rule:     RuleCheck,
+		Severity: SeverityError,
+		File:     options.ManifestPath,
+		Line:     manifestApp.Lines.Name,
+		Message:  err.Error(),
+	}
+}
+
+// serialConnection makes one plugin RPC call at a time, as the cf CLI's RPC
+// server shares state between calls, and applies the timeout and retries to
+// each call. Requests to the Cloud Controller API don't go through it, so
+// they still run in parallel.
+type serialConnection struct {
+	plugin.CliConnection
+
+	mu      *sync.Mutex
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+}
+
+func newSerialConnection(cliConnection plugin.CliConnection, options Options) *serialConnection {
+	return &serialConnection{
+		CliConnection: cliConnection,
+		mu:            &sync.Mutex{},
+		timeout:       options.Timeout,
+		retries:       options.Retries,
+		backoff:       options.Backoff,
+	}
+}
+
+func (c *serialConnection) GetApp(appName string) (plugin_models.GetAppModel, error) {
+	var app plugin_models.GetAppModel
+	err := c.call(func() (err error) {
+		app, err = c.CliConnection.GetApp(appName)
+		return err
+	})
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+	return app, nil
+}
+
+func (c *serialConnection) GetCurrentSpace() (plugin_models.Space, error) {
+	var space plugin_models.Space
+	err := c.call(func() (err error) {
+		space, err = c.CliConnection.GetCurrentSpace()
+		return err
+	})
+	if err != nil {
+		return plugin_models.Space{}, err
+	}
+	return space, nil
+}
+
+func (c *serialConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	var output []string
+	err := c.call(func() (err error) {
+		output, err = c.CliConnection.CliCommandWithoutTerminalOutput(args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (c *serialConnection) ApiEndpoint() (string, error) {
+	return c.callString(c.CliConnection.ApiEndpoint)
+}
+
+func (c *serialConnection) AccessToken() (string, error) {
+	return c.callString(c.CliConnection.AccessToken)
+}
+
+func (c *serialConnection) IsSSLDisabled() (bool, error) {
+	var disabled bool
+	err := c.call(func() (err error) {
+		disabled, err = c.CliConnection.IsSSLDisabled()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return disabled, nil
+}
+
+func (c *serialConnection) callString(f func() (string, error)) (string, error) {
+	var s string
+	err := c.call(func() (err error) {
+		s, err = f()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// call makes an RPC call, retrying it with backoff when it fails with a
+// transient error.
+func (c *serialConnection) call(f func() error) error {
+	wait := c.backoff
+
+	for attempt := 0; ; attempt++ {
+		err := c.callOnce(f)
+		if err == nil || attempt >= c.retries || !isTransientRPCError(err) {
+			return err
+		}
+
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// callOnce makes an RPC call, giving up on it after the timeout, which
+// includes waiting for the connection. A call which timed out holds on to
+// the connection until it returns, so its result can't be mixed up with a
+// later call's, and a call abandoned while waiting isn't made at all.
+func (c *serialConnection) callOnce(f func() error) error {
+	done := make(chan error, 1)
+	abandoned := make(chan struct{})
+
+	go func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		select {
+		case <-abandoned:
+			return
+		default:
+		}
+		done <- f()
+	}()
+
+	if c.timeout <= 0 {
+		return <-done
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		close(abandoned)
+		return errRPCTimeout{timeout: c.timeout}
+	}
+}
+
+type errRPCTimeout struct {
+	timeout time.Duration
+}
+
+func (e errRPCTimeout) Error() string {
+	return fmt.Sprintf("cf CLI didn't respond within %s", e.timeout)
+}
+
+// isTransientRPCError reports whether an RPC call failed because the cf CLI
+// couldn't be reached in time, rather than because of what was asked.
+func isTransientRPCError(err error) bool {
+	switch err.(type) {
+	case errRPCTimeout, net.Error:
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == rpc.ErrShutdown
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+const RuleCheck = "check"
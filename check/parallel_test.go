@@ -0,0 +1,244 @@
+package check_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/internal/fakecc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// checkSpaceRequests checks n apps, each with an ENV var which differs from
+// the manifest, and counts the requests made to the Cloud Controller and the
+// cf CLI.
+func checkSpaceRequests(n int) int {
+	space := fakecc.NewSpace(n)
+	server := httptest.NewServer(space)
+	defer server.Close()
+
+	manifestFile, err := ioutil.TempFile("", "antifreeze-manifest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(manifestFile.Name())
+
+	fmt.Fprintln(manifestFile, "applications:")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(manifestFile, "- name: app-%d\n  env:\n    ENV_VAR: changed\n  services:\n  - db-%d\n", i, i)
+	}
+	manifestFile.Close()
+
+	connection := &pluginfakes.FakeCliConnection{}
+	connection.ApiEndpointReturns(server.URL, nil)
+	connection.AccessTokenReturns("bearer token", nil)
+	connection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Guid: "space-guid", Name: "space-name"}}, nil)
+
+	findings, err := NewChecker(connection, Options{ManifestPath: manifestFile.Name(), Concurrency: 4}).Check()
+	if err != nil {
+		panic(err)
+	}
+	if !HasErrors(findings) {
+		panic("expected every app to have drifted")
+	}
+
+	return space.Requests + connection.GetCurrentSpaceCallCount()
+}
+
+var _ = Describe("Checking several apps", func() {
+	var cliConnection *pluginfakes.FakeCliConnection
+	var options Options
+	var space *fakecc.Space
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		space = &fakecc.Space{
+			Delay: 5 * time.Millisecond,
+			Apps: []fakecc.App{
+				{Name: "app-1", Env: map[string]interface{}{"SURPRISE": "yes"}},
+				{Name: "app-2", Env: map[string]interface{}{"SURPRISE": "yes"}},
+			},
+		}
+		server = httptest.NewServer(space)
+
+		options = Options{ManifestPath: "../fixtures/multi-manifest.yml", Concurrency: 2, Backoff: time.Millisecond}
+		cliConnection = &pluginfakes.FakeCliConnection{}
+		cliConnection.ApiEndpointReturns(server.URL, nil)
+		cliConnection.AccessTokenReturns("bearer token", nil)
+		cliConnection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Guid: "space-guid", Name: "space-name"}}, nil)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("checks every app in the manifest, reporting them in manifest order", func() {
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+
+		var apps []string
+		for _, f := range findings {
+			if f.Rule == RuleUnexpectedEnv {
+				apps = append(apps, f.App)
+			}
+		}
+		Expect(apps).To(Equal([]string{"app-1", "app-2"}))
+	})
+
+	It("fetches the space in bulk instead of each app", func() {
+		_, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cliConnection.GetAppCallCount()).To(Equal(0))
+	})
+
+	It("makes the same requests however many apps there are", func() {
+		Expect(checkSpaceRequests(1)).To(Equal(9))
+		Expect(checkSpaceRequests(10)).To(Equal(9))
+		Expect(checkSpaceRequests(50)).To(Equal(9))
+	})
+
+	It("only fetches the sidecars of apps which declare some", func() {
+		options.OpsPaths = []string{"../fixtures/ops/sidecar.yml"}
+
+		_, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(space.Paths).To(ContainElement("/v3/apps/app-1-guid/sidecars"))
+		Expect(space.Paths).ToNot(ContainElement("/v3/apps/app-2-guid/sidecars"))
+	})
+
+	It("checks no more apps at once than the concurrency allows", func() {
+		options.Concurrency = 1
+
+		_, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(space.MaxAtOnce).To(Equal(1))
+	})
+
+	It("reports apps which couldn't be checked and carries on", func() {
+		space.Apps = space.Apps[1:]
+
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(findings[0]).To(Equal(Finding{
+			App:      "app-1",
+			Rule:     RuleCheck,
+			Severity: SeverityError,
+			File:     "../fixtures/multi-manifest.yml",
+			Line:     3,
+			Message:  "Unable to get app 'app-1': not found in space 'space-name'",
+		}))
+		Expect(findings[1].App).To(Equal("app-2"))
+	})
+
+	It("still checks metadata when the sidecars can't be fetched", func() {
+		space.Apps[0].Labels = map[string]string{"team": "payments"}
+		space.Failing = []string{"/sidecars"}
+		options.OpsPaths = []string{"../fixtures/ops/sidecar.yml"}
+
+		findings, err := NewChecker(cliConnection, options).Check()
+		Expect(err).ToNot(HaveOccurred())
+
+		var rules []string
+		for _, f := range findings {
+			if f.App == "app-1" {
+				rules = append(rules, f.Rule)
+			}
+		}
+		Expect(rules).To(ContainElement(RuleSidecars))
+		Expect(rules).To(ContainElement("unexpected-label"))
+		Expect(rules).ToNot(ContainElement(RuleMetadata))
+	})
+
+	Context("a single app", func() {
+		BeforeEach(func() {
+			options.AppName = "app-2"
+			options.Retries = 2
+		})
+
+		It("retries RPC calls which fail to reach the cf CLI", func() {
+			calls := 0
+			cliConnection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+				calls++
+				if calls == 1 {
+					return plugin_models.GetAppModel{}, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+				}
+				return plugin_models.GetAppModel{Guid: "app-2-guid"}, nil
+			}
+
+			_, err := NewChecker(cliConnection, options).Check()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(calls).To(Equal(2))
+		})
+
+		It("doesn't retry errors which won't go away", func() {
+			cliConnection.GetAppStub = nil
+			cliConnection.GetAppReturns(plugin_models.GetAppModel{}, errors.New("App app-2 not found"))
+
+			_, err := NewChecker(cliConnection, options).Check()
+			Expect(err).To(MatchError("Unable to get app 'app-2': App app-2 not found"))
+			Expect(cliConnection.GetAppCallCount()).To(Equal(1))
+		})
+
+		It("gives up on RPC calls which take too long", func() {
+			options.Timeout = 10 * time.Millisecond
+			options.Retries = 0
+			cliConnection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+				time.Sleep(50 * time.Millisecond)
+				return plugin_models.GetAppModel{}, nil
+			}
+
+			_, err := NewChecker(cliConnection, options).Check()
+			Expect(err).To(MatchError("Unable to get app 'app-2': cf CLI didn't respond within 10ms"))
+		})
+
+		It("doesn't wait on a hung RPC call when retrying", func() {
+			options.Timeout = 10 * time.Millisecond
+			options.Retries = 2
+			hung := make(chan struct{})
+			defer close(hung)
+			cliConnection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+				<-hung
+				return plugin_models.GetAppModel{}, nil
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := NewChecker(cliConnection, options).Check()
+				done <- err
+			}()
+
+			var err error
+			Eventually(done, time.Second).Should(Receive(&err))
+			Expect(err).To(MatchError("Unable to get app 'app-2': cf CLI didn't respond within 10ms"))
+			Expect(cliConnection.GetAppCallCount()).To(Equal(1))
+		})
+	})
+})
+
+func BenchmarkCheck(b *testing.B) {
+	for _, n := range []int{10, 25, 50} {
+		b.Run(fmt.Sprintf("%d apps", n), func(b *testing.B) {
+			one := checkSpaceRequests(1)
+			b.ResetTimer()
+
+			var requests int
+			for i := 0; i < b.N; i++ {
+				r := checkSpaceRequests(n)
+				if r != one {
+					b.Fatalf("checking %d apps made %d requests, %d for one app", n, r, one)
+				}
+				requests += r
+			}
+			b.ReportMetric(float64(requests)/float64(b.N), "requests/op")
+		})
+	}
+}
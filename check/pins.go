@@ -0,0 +1,160 @@
+package check
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/internal/secrets"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+// YPins is a companion file pinning secret ENV vars to the SHA-256 digest of
+// their value, so they can be checked without being kept in git:
+//
+//	applications:
+//	- name: my-app
+//	  env:
+//	    DATABASE_PASSWORD: sha256:5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8
+type YPins struct {
+	Applications []YPinnedApp `yaml:"applications"`
+}
+
+type YPinnedApp struct {
+	Name string            `yaml:"name"`
+	Env  map[string]string `yaml:"env"`
+
+	// Line and EnvKeys locate the app and each pinned ENV var in the pins
+	// file.
+	Line    int            `yaml:"-"`
+	EnvKeys map[string]int `yaml:"-"`
+}
+
+const pinPrefix = "sha256:"
+
+var pinPattern = regexp.MustCompile(`^sha256:[0-9a-fA-F]{64}$`)
+
+// ParsePin returns the digest of a `sha256:<hex>` value.
+func ParsePin(value interface{}) (string, bool) {
+	s, ok := value.(string)
+	if !ok || !pinPattern.MatchString(s) {
+		return "", false
+	}
+	return strings.ToLower(strings.TrimPrefix(s, pinPrefix)), true
+}
+
+// EnvDigest hashes an ENV value the way it reaches the app, so JSON values
+// are hashed as their JSON representation. A value redacted from a
+// recorded session is already its digest.
+func EnvDigest(value interface{}) string {
+	if s, ok := value.(string); ok {
+		if digest, redacted := secrets.RedactedDigest(s); redacted {
+			return digest
+		}
+	}
+
+	sum := sha256.Sum256([]byte(manifest.EnvValueString(value)))
+	return hex.EncodeToString(sum[:])
+}
+
+// envValuesMatch compares ENV values by digest, so values redacted from a
+// recorded session still compare with the manifest's.
+func envValuesMatch(manifestValue, appValue interface{}) bool {
+	return EnvDigest(manifestValue) == EnvDigest(appValue)
+}
+
+// LoadPins reads the pins for one app. An app the file doesn't mention has
+// no pinned ENV vars.
+func LoadPins(pinsPath, appName string) (YPinnedApp, error) {
+	return loadPins(manifest.Read, pinsPath, appName)
+}
+
+// loadPins is LoadPins, reading the file with read.
+func loadPins(read manifest.ReadFunc, pinsPath, appName string) (YPinnedApp, error) {
+	var pins YPins
+	root, err := manifest.LoadCompanionFileWith(read, pinsPath, "pins file", &pins)
+
+	if err != nil {
+		return YPinnedApp{}, err
+	}
+
+	nodes := manifest.ApplicationNodes(root)
+
+	for i, app := range pins.Applications {
+		if app.Name != appName {
+			continue
+		}
+
+		for k, v := range app.Env {
+			if _, ok := ParsePin(v); !ok {
+				return YPinnedApp{}, fmt.Errorf("Invalid pin for ENV var '%s' in pins file, expected %s<hex digest>", k, pinPrefix)
+			}
+		}
+
+		if i < len(nodes) {
+			app.Line = nodes[i].Line
+			if _, env := manifest.MappingEntry(nodes[i], "env"); env != nil {
+				app.EnvKeys = manifest.KeyLines(env)
+			}
+		}
+
+		return app, nil
+	}
+
+	return YPinnedApp{Name: appName}, nil
+}
+
+// ComparePins hashes the app's value of each pinned ENV var and compares it
+// with the pinned digest. Neither values nor digests are reported.
+func ComparePins(pinsPath string, pins YPinnedApp, app plugin_models.GetAppModel) (findings []Finding) {
+	var keys []string
+	for k := range pins.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		finding := Finding{
+			App:      pins.Name,
+			Key:      k,
+			Rule:     RulePinnedEnv,
+			Severity: SeverityError,
+			File:     pinsPath,
+			Line:     pins.EnvKeys[k],
+		}
+
+		value, ok := app.EnvironmentVars[k]
+		digest, _ := ParsePin(pins.Env[k])
+
+		switch {
+		case !ok:
+			finding.Message = fmt.Sprintf("App '%s' is missing pinned ENV var '%s'", pins.Name, k)
+		case EnvDigest(value) != digest:
+			finding.Message = fmt.Sprintf("App '%s' has ENV var '%s' with a value which doesn't match its pinned digest", pins.Name, k)
+		default:
+			continue
+		}
+
+		findings = append(findings, finding)
+	}
+
+	return findings
+}
+
+// WithoutPinnedEnv drops findings asking for pinned ENV vars to be added to
+// the manifest, as the pins file declares them instead.
+func WithoutPinnedEnv(findings []Finding, pins YPinnedApp) (kept []Finding) {
+	for _, f := range findings {
+		if _, pinned := pins.Env[f.Key]; pinned && (f.Rule == RuleUnexpectedEnv || f.Rule == RuleTypoEnv || f.Rule == RulePlatformEnv) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+const RulePinnedEnv = "pinned-env"
@@ -0,0 +1,116 @@
+package check_test
+
+import (
+	"github.com/cloudfoundry/cli/plugin/models"
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pins", func() {
+	const hunter2Digest = "f52fbd32b2b3b86ff88ef6c490628285f482af15ddcb29541f94bcf526a3f6c7"
+
+	var pins YPinnedApp
+	var app plugin_models.GetAppModel
+
+	BeforeEach(func() {
+		var err error
+		pins, err = LoadPins("../fixtures/pins.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		app = plugin_models.GetAppModel{
+			EnvironmentVars: map[string]interface{}{
+				"DATABASE_PASSWORD": "hunter2",
+				"API_TOKEN":         "token",
+			},
+		}
+	})
+
+	It("parses sha256 pins", func() {
+		digest, ok := ParsePin("sha256:" + hunter2Digest)
+		Expect(ok).To(BeTrue())
+		Expect(digest).To(Equal(hunter2Digest))
+
+		_, ok = ParsePin("sha256:not-hex")
+		Expect(ok).To(BeFalse())
+
+		_, ok = ParsePin(1800)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("hashes values the way they reach the app", func() {
+		Expect(EnvDigest("hunter2")).To(Equal(hunter2Digest))
+		Expect(EnvDigest(map[interface{}]interface{}{"a": 1})).To(Equal(EnvDigest(`{"a":1}`)))
+	})
+
+	It("has no findings when the live values match their pins", func() {
+		Expect(ComparePins("pins.yml", pins, app)).To(BeEmpty())
+	})
+
+	It("reports rotated values without printing them", func() {
+		app.EnvironmentVars["DATABASE_PASSWORD"] = "rotated"
+
+		Expect(ComparePins("pins.yml", pins, app)).To(Equal([]Finding{{
+			App:      "app-name",
+			Key:      "DATABASE_PASSWORD",
+			Rule:     RulePinnedEnv,
+			Severity: SeverityError,
+			File:     "pins.yml",
+			Line:     5,
+			Message:  "App 'app-name' has ENV var 'DATABASE_PASSWORD' with a value which doesn't match its pinned digest",
+		}}))
+	})
+
+	It("reports pinned ENV vars the app doesn't have", func() {
+		delete(app.EnvironmentVars, "API_TOKEN")
+
+		findings := ComparePins("pins.yml", pins, app)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Message).To(Equal("App 'app-name' is missing pinned ENV var 'API_TOKEN'"))
+		Expect(findings[0].Line).To(Equal(6))
+	})
+
+	It("stops asking for pinned ENV vars to be added to the manifest", func() {
+		findings := WithoutPinnedEnv([]Finding{
+			{Key: "DATABASE_PASSWORD", Rule: RuleUnexpectedEnv},
+			{Key: "SNOW_FLAKE_VAR", Rule: RuleUnexpectedEnv},
+		}, pins)
+
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Key).To(Equal("SNOW_FLAKE_VAR"))
+	})
+
+	It("rejects values which aren't pins", func() {
+		_, err := LoadPins("../fixtures/invalid-pins.yml", "app-name")
+		Expect(err).To(MatchError("Invalid pin for ENV var 'DATABASE_PASSWORD' in pins file, expected sha256:<hex digest>"))
+	})
+
+	Context("pinned in the manifest", func() {
+		It("compares the digest of the live value", func() {
+			manifestApp := manifest.YApplication{
+				Name:  "app-name",
+				Env:   map[string]interface{}{"DATABASE_PASSWORD": "sha256:" + hunter2Digest, "API_TOKEN": "token"},
+				Lines: manifest.YLines{EnvKeys: map[string]int{"DATABASE_PASSWORD": 6}},
+			}
+
+			Expect(CompareApp("manifest.yml", manifestApp, app)).To(BeEmpty())
+
+			app.EnvironmentVars["DATABASE_PASSWORD"] = "rotated"
+			Expect(CompareApp("manifest.yml", manifestApp, app)).To(Equal([]Finding{{
+				App:      "app-name",
+				Key:      "DATABASE_PASSWORD",
+				Rule:     RuleChangedEnv,
+				Severity: SeverityError,
+				File:     "manifest.yml",
+				Line:     6,
+				Message:  "App 'app-name' has ENV var 'DATABASE_PASSWORD' with a value which doesn't match its pinned digest",
+
+				Remediation: &Remediation{
+					Manifest: "env:\n  DATABASE_PASSWORD: sha256:" + EnvDigest("rotated"),
+					Ops:      []manifest.Op{{Type: "replace", Path: "/applications/name=app-name/env/DATABASE_PASSWORD?", Value: "sha256:" + EnvDigest("rotated")}},
+				},
+			}}))
+		})
+	})
+})
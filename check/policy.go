@@ -0,0 +1,433 @@
+package check
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+// YPolicy is a file of house rules which apps must follow on top of matching
+// their manifest, such as a minimum number of instances in production:
+//
+//	rules:
+//	- name: production-instances
+//	  severity: error
+//	  spaces: [production]
+//	  attribute: instances
+//	  min: 2
+type YPolicy struct {
+	Rules []YPolicyRule `yaml:"rules"`
+}
+
+// YPolicyRule constrains one attribute of the apps, spaces and process
+// types it selects. Empty selectors select everything.
+type YPolicyRule struct {
+	Name     string `yaml:"name"`
+	Severity string `yaml:"severity"`
+
+	Apps               []string `yaml:"apps"`
+	Spaces             []string `yaml:"spaces"`
+	ProcessTypes       []string `yaml:"process-types"`
+	ExceptProcessTypes []string `yaml:"except-process-types"`
+
+	Attribute string   `yaml:"attribute"`
+	Min       string   `yaml:"min"`
+	Max       string   `yaml:"max"`
+	Pattern   string   `yaml:"pattern"`
+	OneOf     []string `yaml:"one-of"`
+	NotOneOf  []string `yaml:"not-one-of"`
+	Forbidden bool     `yaml:"forbidden"`
+
+	Line int `yaml:"-"`
+}
+
+const (
+	policyInstances       = "instances"
+	policyMemory          = "memory"
+	policyDiskQuota       = "disk_quota"
+	policyHealthCheckType = "health-check-type"
+	policyRandomRoute     = "random-route"
+	policyEnvKeys         = "env-keys"
+)
+
+var policyAttributes = []string{
+	policyInstances,
+	policyMemory,
+	policyDiskQuota,
+	policyHealthCheckType,
+	policyRandomRoute,
+	policyEnvKeys,
+}
+
+// LoadPolicy reads a policy file and checks each rule can be evaluated.
+func LoadPolicy(policyPath string) (YPolicy, error) {
+	return loadPolicy(manifest.Read, policyPath)
+}
+
+// loadPolicy is LoadPolicy, reading the file with read.
+func loadPolicy(read manifest.ReadFunc, policyPath string) (YPolicy, error) {
+	var policy YPolicy
+	root, err := manifest.LoadCompanionFileWith(read, policyPath, "policy file", &policy)
+
+	if err != nil {
+		return YPolicy{}, err
+	}
+
+	if root == nil {
+		return policy, nil
+	}
+
+	if rules := manifest.MappingValue(root, "rules"); rules != nil {
+		for i := range policy.Rules {
+			if i < len(rules.Content) {
+				policy.Rules[i].Line = rules.Content[i].Line
+			}
+		}
+	}
+
+	for i, rule := range policy.Rules {
+		if rule.Severity == "" {
+			policy.Rules[i].Severity = SeverityError
+		}
+
+		if err := rule.validate(); err != nil {
+			return YPolicy{}, err
+		}
+	}
+
+	return policy, nil
+}
+
+func (r YPolicyRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("Policy rule on line %d has no name", r.Line)
+	}
+
+	if r.Severity != "" && r.Severity != SeverityError && r.Severity != SeverityWarning {
+		return fmt.Errorf("Policy rule '%s' has unknown severity '%s'", r.Name, r.Severity)
+	}
+
+	if !stringInSlice(r.Attribute, policyAttributes) {
+		return fmt.Errorf("Policy rule '%s' has unknown attribute '%s'", r.Name, r.Attribute)
+	}
+
+	for _, limit := range []string{r.Min, r.Max} {
+		if limit == "" {
+			continue
+		}
+		if _, err := r.number(limit); err != nil {
+			return fmt.Errorf("Policy rule '%s' has invalid limit '%s' for %s", r.Name, limit, r.Attribute)
+		}
+	}
+
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("Policy rule '%s' has invalid pattern '%s'", r.Name, r.Pattern)
+	}
+
+	return nil
+}
+
+// number reads a limit or value of the rule's attribute, in megabytes for
+// sizes.
+func (r YPolicyRule) number(value string) (int64, error) {
+	if r.Attribute == policyMemory || r.Attribute == policyDiskQuota {
+		return manifest.SizeInMB(value)
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// selects reports whether the rule applies to an app in a space.
+func (r YPolicyRule) selects(appName, spaceName string) bool {
+	return matchesAny(appName, r.Apps) && matchesAny(spaceName, r.Spaces)
+}
+
+func (r YPolicyRule) selectsProcess(processType string) bool {
+	if processType == "" {
+		return true
+	}
+	if len(r.ExceptProcessTypes) > 0 && matchesAny(processType, r.ExceptProcessTypes) {
+		return false
+	}
+	return matchesAny(processType, r.ProcessTypes)
+}
+
+// matchesAny matches a name against glob patterns such as `prod*`. No
+// patterns match every name.
+func matchesAny(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPolicy evaluates the policy for an app, looking up the current space
+// when a rule selects apps by space.
+func CheckPolicy(cliConnection plugin.CliConnection, manifestPath string, policy YPolicy, manifestApp manifest.YApplication, app plugin_models.GetAppModel) ([]Finding, error) {
+	var spaceName string
+
+	if policy.usesSpaces() {
+		space, err := cliConnection.GetCurrentSpace()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get current space: %s", err)
+		}
+		spaceName = space.Name
+	}
+
+	return EvaluatePolicy(manifestPath, policy, manifestApp, app, spaceName), nil
+}
+
+// usesSpaces reports whether any rule selects apps by space, which needs
+// the current space looked up.
+func (p YPolicy) usesSpaces() bool {
+	for _, r := range p.Rules {
+		if len(r.Spaces) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// policyValue is one value of a rule's attribute, as declared in the
+// manifest or as found on the app.
+type policyValue struct {
+	process string
+	key     string
+	value   string
+	line    int
+	live    bool
+}
+
+// EvaluatePolicy checks the manifest entry and the app against each rule
+// selecting them. Values found on the app are only reported when they
+// differ from the manifest's, so one violation isn't reported twice.
+func EvaluatePolicy(manifestPath string, policy YPolicy, manifestApp manifest.YApplication, app plugin_models.GetAppModel, spaceName string) (findings []Finding) {
+	for _, rule := range policy.Rules {
+		if !rule.selects(manifestApp.Name, spaceName) {
+			continue
+		}
+
+		for _, v := range policyValues(rule.Attribute, manifestApp, app) {
+			if !rule.selectsProcess(v.process) {
+				continue
+			}
+
+			requirement, ok := rule.violation(v.value)
+			if !ok {
+				continue
+			}
+
+			where := "in the manifest"
+			if v.live {
+				where = "in Cloud Foundry"
+			}
+
+			key := v.key
+			if key == "" {
+				key = v.process
+			}
+
+			findings = append(findings, Finding{
+				App:      manifestApp.Name,
+				Key:      key,
+				Rule:     policyRulePrefix + rule.Name,
+				Severity: rule.Severity,
+				File:     manifestPath,
+				Line:     v.line,
+				Message:  fmt.Sprintf("App '%s' %s %s, %s (policy '%s')", manifestApp.Name, v.subject(rule.Attribute), where, requirement, rule.Name),
+			})
+		}
+	}
+
+	return findings
+}
+
+func (v policyValue) subject(attribute string) string {
+	switch {
+	case attribute == policyEnvKeys:
+		return fmt.Sprintf("has ENV var '%s'", v.key)
+	case v.process != "":
+		return fmt.Sprintf("process '%s' has %s %s", v.process, attribute, v.value)
+	}
+	return fmt.Sprintf("has %s %s", attribute, v.value)
+}
+
+// violation describes the requirement a value breaks, if any.
+func (r YPolicyRule) violation(value string) (string, bool) {
+	if r.Forbidden {
+		if value != "" && value != "false" {
+			return "which isn't allowed", true
+		}
+		return "", false
+	}
+
+	if r.Min != "" || r.Max != "" {
+		n, err := r.number(value)
+		if err != nil {
+			return "", false
+		}
+
+		if min, _ := r.number(r.Min); r.Min != "" && n < min {
+			return fmt.Sprintf("at least %s required", r.Min), true
+		}
+
+		if max, _ := r.number(r.Max); r.Max != "" && n > max {
+			return fmt.Sprintf("at most %s allowed", r.Max), true
+		}
+	}
+
+	if r.Pattern != "" && !regexp.MustCompile(r.Pattern).MatchString(value) {
+		return fmt.Sprintf("which must match %s", r.Pattern), true
+	}
+
+	if len(r.OneOf) > 0 && !stringInSlice(value, r.OneOf) {
+		return fmt.Sprintf("which must be one of %s", strings.Join(r.OneOf, ", ")), true
+	}
+
+	if stringInSlice(value, r.NotOneOf) {
+		return "which isn't allowed", true
+	}
+
+	return "", false
+}
+
+// policyValues collects an attribute's values from the manifest and the
+// app. The app's attributes are those of its web process.
+func policyValues(attribute string, manifestApp manifest.YApplication, app plugin_models.GetAppModel) (values []policyValue) {
+	lines := manifestApp.Lines
+	processes := declaredProcesses(manifestApp)
+
+	var live *policyValue
+
+	switch attribute {
+	case policyInstances:
+		for _, p := range processes {
+			if p.Instances != nil {
+				values = append(values, policyValue{process: p.Type, value: strconv.Itoa(*p.Instances), line: processLine(lines, p.Type, attribute)})
+			}
+		}
+		live = &policyValue{process: webProcess, value: strconv.Itoa(app.InstanceCount)}
+	case policyMemory, policyDiskQuota:
+		for _, p := range processes {
+			size := p.Memory
+			if attribute == policyDiskQuota {
+				size = p.DiskQuota
+			}
+			if size != "" {
+				values = append(values, policyValue{process: p.Type, value: size, line: processLine(lines, p.Type, attribute)})
+			}
+		}
+		size := app.Memory
+		if attribute == policyDiskQuota {
+			size = app.DiskQuota
+		}
+		live = &policyValue{process: webProcess, value: fmt.Sprintf("%dM", size)}
+	case policyHealthCheckType:
+		for _, p := range processes {
+			if p.HealthCheckType != "" {
+				values = append(values, policyValue{process: p.Type, value: healthCheckType(p.HealthCheckType), line: processLine(lines, p.Type, attribute)})
+			}
+		}
+	case policyRandomRoute:
+		if manifestApp.RandomRoute {
+			values = append(values, policyValue{value: "true", line: lines.Keys[attribute]})
+		}
+	case policyEnvKeys:
+		var keys []string
+		for k := range manifestApp.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			values = append(values, policyValue{key: k, value: k, line: lines.EnvKeys[k]})
+		}
+
+		keys = nil
+		for k := range app.EnvironmentVars {
+			if _, declared := manifestApp.Env[k]; !declared {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			values = append(values, policyValue{key: k, value: k, line: blockLine(lines.Env, lines), live: true})
+		}
+	}
+
+	if live == nil || live.value == "0" || live.value == "0M" {
+		return values
+	}
+
+	for _, v := range values {
+		if v.process == live.process {
+			if v.value == live.value || sameSize(attribute, v.value, live.value) {
+				return values
+			}
+			live.line = v.line
+		}
+	}
+
+	if live.line == 0 {
+		live.line = lines.Name
+	}
+	live.live = true
+
+	return append(values, *live)
+}
+
+// declaredProcesses lists the processes the manifest declares, with the
+// web process described by the app's own attributes unless `processes:`
+// declares it.
+func declaredProcesses(manifestApp manifest.YApplication) []manifest.YProcess {
+	processes := manifestApp.Processes
+
+	for _, p := range processes {
+		if p.Type == webProcess {
+			return processes
+		}
+	}
+
+	web := manifest.YProcess{
+		Type:            webProcess,
+		Instances:       manifestApp.Instances,
+		Memory:          manifestApp.Memory,
+		DiskQuota:       manifestApp.DiskQuota,
+		HealthCheckType: manifestApp.HealthCheckType,
+	}
+
+	return append([]manifest.YProcess{web}, processes...)
+}
+
+// processLine locates a process's attribute, which for a web process not in
+// `processes:` is declared on the app.
+func processLine(lines manifest.YLines, processType, attribute string) int {
+	if item, ok := lines.Processes[processType]; ok {
+		return item.Keys[attribute]
+	}
+	return lines.Keys[attribute]
+}
+
+func sameSize(attribute, a, b string) bool {
+	if attribute != policyMemory && attribute != policyDiskQuota {
+		return false
+	}
+	aMB, errA := manifest.SizeInMB(a)
+	bMB, errB := manifest.SizeInMB(b)
+	return errA == nil && errB == nil && aMB == bMB
+}
+
+const (
+	policyRulePrefix = "policy/"
+	RulePolicy       = "policy"
+)
@@ -0,0 +1,142 @@
+package check_test
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	var policy YPolicy
+	var manifestApp manifest.YApplication
+	var app plugin_models.GetAppModel
+
+	BeforeEach(func() {
+		var err error
+		policy, err = LoadPolicy("../fixtures/policy.yml")
+		Expect(err).ToNot(HaveOccurred())
+
+		manifestApp, err = manifest.LoadApplication("../fixtures/policy-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		app = plugin_models.GetAppModel{
+			InstanceCount:   1,
+			Memory:          8192,
+			EnvironmentVars: map[string]interface{}{"LOG_LEVEL": "debug", "apiKey": "abc"},
+		}
+	})
+
+	rules := func(findings []Finding) (rules []string) {
+		for _, f := range findings {
+			rules = append(rules, f.Rule)
+		}
+		return rules
+	}
+
+	It("reports violations at the offending manifest line", func() {
+		findings := EvaluatePolicy("manifest.yml", policy, manifestApp, app, "production")
+
+		Expect(findings).To(ContainElement(Finding{
+			App:      "app-name",
+			Key:      "web",
+			Rule:     "policy/production-instances",
+			Severity: SeverityError,
+			File:     "manifest.yml",
+			Line:     4,
+			Message:  "App 'app-name' process 'web' has instances 1 in the manifest, at least 2 required (policy 'production-instances')",
+		}))
+		Expect(findings).To(ContainElement(Finding{
+			App:      "app-name",
+			Key:      "apiKey",
+			Rule:     "policy/env-names",
+			Severity: SeverityWarning,
+			File:     "manifest.yml",
+			Line:     9,
+			Message:  "App 'app-name' has ENV var 'apiKey' in the manifest, which must match ^[A-Z][A-Z0-9_]*$ (policy 'env-names')",
+		}))
+		Expect(rules(findings)).To(Equal([]string{
+			"policy/production-instances",
+			"policy/env-names",
+			"policy/no-random-route-in-production",
+			"policy/memory-cap",
+			"policy/process-health-checks-for-workers",
+		}))
+	})
+
+	It("only applies rules to the spaces they select", func() {
+		findings := EvaluatePolicy("manifest.yml", policy, manifestApp, app, "staging")
+
+		Expect(rules(findings)).ToNot(ContainElement("policy/production-instances"))
+		Expect(rules(findings)).ToNot(ContainElement("policy/no-random-route-in-production"))
+	})
+
+	It("only applies rules to the process types they select", func() {
+		findings := EvaluatePolicy("manifest.yml", policy, manifestApp, app, "staging")
+
+		var keys []string
+		for _, f := range findings {
+			if f.Rule == "policy/process-health-checks-for-workers" {
+				keys = append(keys, f.Key)
+			}
+		}
+		Expect(keys).To(Equal([]string{"clock"}))
+	})
+
+	It("checks the app where it differs from the manifest", func() {
+		manifestApp.Memory = "1G"
+		app.EnvironmentVars["debug_mode"] = "true"
+
+		findings := EvaluatePolicy("manifest.yml", policy, manifestApp, app, "staging")
+
+		Expect(findings).To(ContainElement(Finding{
+			App:      "app-name",
+			Key:      "web",
+			Rule:     "policy/memory-cap",
+			Severity: SeverityError,
+			File:     "manifest.yml",
+			Line:     5,
+			Message:  "App 'app-name' process 'web' has memory 8192M in Cloud Foundry, at most 4G allowed (policy 'memory-cap')",
+		}))
+		Expect(findings).To(ContainElement(Finding{
+			App:      "app-name",
+			Key:      "debug_mode",
+			Rule:     "policy/env-names",
+			Severity: SeverityWarning,
+			File:     "manifest.yml",
+			Line:     7,
+			Message:  "App 'app-name' has ENV var 'debug_mode' in Cloud Foundry, which must match ^[A-Z][A-Z0-9_]*$ (policy 'env-names')",
+		}))
+	})
+
+	It("rejects rules it can't evaluate", func() {
+		_, err := LoadPolicy("../fixtures/invalid-policy.yml")
+		Expect(err).To(MatchError("Policy rule 'typo' has unknown attribute 'memroy'"))
+	})
+
+	Context("checking an app", func() {
+		var cliConnection *pluginfakes.FakeCliConnection
+
+		BeforeEach(func() {
+			cliConnection = &pluginfakes.FakeCliConnection{}
+			cliConnection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Name: "production"}}, nil)
+		})
+
+		It("looks up the current space", func() {
+			findings, err := CheckPolicy(cliConnection, "manifest.yml", policy, manifestApp, app)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rules(findings)).To(ContainElement("policy/production-instances"))
+		})
+
+		It("returns an error when the space can't be found", func() {
+			cliConnection.GetCurrentSpaceReturns(plugin_models.Space{}, errors.New("not targeted"))
+
+			_, err := CheckPolicy(cliConnection, "manifest.yml", policy, manifestApp, app)
+			Expect(err).To(MatchError("Unable to get current space: not targeted"))
+		})
+	})
+})
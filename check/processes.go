@@ -0,0 +1,226 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+// CheckProcesses compares the process types an app runs with those its
+// manifest declares.
+func CheckProcesses(client *cc.Client, appGUID, manifestPath string, manifestApp manifest.YApplication) ([]Finding, error) {
+	processes, err := client.GetProcesses(appGUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CompareProcesses(manifestPath, manifestApp, processes), nil
+}
+
+// CompareProcesses reports declared process types the app doesn't run,
+// declared attributes which differ, and process types the app runs without
+// declaring them, unless they're scaled to zero. Unless `processes:`
+// declares it, the web process is described by the app's own attributes,
+// such as `instances:`.
+func CompareProcesses(manifestPath string, manifestApp manifest.YApplication, processes []cc.Process) (findings []Finding) {
+	live := map[string]cc.Process{}
+	for _, p := range processes {
+		live[p.Type] = p
+	}
+
+	for _, declared := range declaredProcesses(manifestApp) {
+		_, inBlock := manifestApp.Lines.Processes[declared.Type]
+		process, ok := live[declared.Type]
+
+		// A web process the manifest only describes by the app's own
+		// attributes has nothing to remove when it's missing.
+		if !ok && !inBlock {
+			continue
+		}
+
+		if !ok {
+			findings = append(findings, Finding{
+				App:      manifestApp.Name,
+				Key:      declared.Type,
+				Rule:     RuleMissingProcess,
+				Severity: SeverityError,
+				File:     manifestPath,
+				Line:     manifestApp.Lines.Processes[declared.Type].Line,
+				Message:  fmt.Sprintf("App '%s' has no '%s' process, which the manifest declares", manifestApp.Name, declared.Type),
+
+				Remediation: adoptRemoval(manifestApp, "processes", "type="+declared.Type),
+			})
+			continue
+		}
+
+		for _, c := range processChanges(declared, process) {
+			message := fmt.Sprintf("App '%s' process '%s' has %s %s, the manifest declares %s", manifestApp.Name, declared.Type, c.attribute, c.actual, c.expected)
+			if c.attribute == "command" {
+				message = fmt.Sprintf("App '%s' process '%s' has a command different from the manifest", manifestApp.Name, declared.Type)
+			}
+
+			var value interface{} = c.actual
+			if c.attribute == "instances" {
+				value = process.Instances
+			}
+
+			tokens := []string{c.attribute}
+			if inBlock {
+				tokens = []string{"processes", "type=" + declared.Type, c.attribute}
+			}
+
+			findings = append(findings, Finding{
+				App:       manifestApp.Name,
+				Key:       declared.Type,
+				Rule:      RuleChangedProcess,
+				Severity:  SeverityError,
+				File:      manifestPath,
+				Line:      processLine(manifestApp.Lines, declared.Type, c.attribute),
+				Message:   message,
+				Attribute: c.attribute,
+				Expected:  c.expected,
+				Actual:    c.actual,
+
+				Remediation: adoptValue(manifestApp, value, tokens...),
+			})
+		}
+	}
+
+	var types []string
+	for t := range live {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, t := range types {
+		// Process types from a Procfile, such as rake or console, exist
+		// with no instances until they're scaled, so they aren't drift.
+		if declaresProcess(manifestApp, t) || live[t].Instances == 0 {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			App:      manifestApp.Name,
+			Key:      t,
+			Rule:     RuleUnexpectedProcess,
+			Severity: SeverityError,
+			File:     manifestPath,
+			Line:     blockLine(manifestApp.Lines.Keys["processes"], manifestApp.Lines),
+			Message:  fmt.Sprintf("App '%s' has unexpected process type '%s' (missing from manifest)", manifestApp.Name, t),
+
+			Remediation: processRemediation(manifestApp, t, live[t].Instances),
+		})
+	}
+
+	return findings
+}
+
+func declaresProcess(manifestApp manifest.YApplication, processType string) bool {
+	for _, p := range declaredProcesses(manifestApp) {
+		if p.Type == processType {
+			return true
+		}
+	}
+	return false
+}
+
+// processRemediation declares an unexpected process type with the app's
+// instances, or scales it to zero.
+func processRemediation(manifestApp manifest.YApplication, processType string, instances int) *Remediation {
+	type process struct {
+		Type      string `yaml:"type"`
+		Instances int    `yaml:"instances"`
+	}
+
+	return &Remediation{
+		Manifest: yamlSnippet(map[string]interface{}{"processes": []process{{processType, instances}}}),
+		Ops: []manifest.Op{{
+			Type:  manifest.OpReplace,
+			Path:  manifest.AppPath(manifestApp.Name, "processes?", "-"),
+			Value: map[string]interface{}{"type": processType, "instances": instances},
+		}},
+		Command: cfCommand("scale", manifestApp.Name, "--process", processType, "-i", "0"),
+	}
+}
+
+type attributeChange struct {
+	attribute string
+	expected  string
+	actual    string
+}
+
+// processChanges compares the attributes a process declares; anything left
+// out of the manifest is whatever the platform chose.
+func processChanges(declared manifest.YProcess, process cc.Process) (changes []attributeChange) {
+	if declared.Command != "" {
+		actual := ""
+		if process.Command != nil {
+			actual = *process.Command
+		}
+		if actual != declared.Command {
+			changes = append(changes, attributeChange{"command", declared.Command, actual})
+		}
+	}
+
+	if declared.Instances != nil && *declared.Instances != process.Instances {
+		changes = append(changes, attributeChange{"instances", strconv.Itoa(*declared.Instances), strconv.Itoa(process.Instances)})
+	}
+
+	if c, changed := sizeChange("memory", declared.Memory, process.MemoryInMB); changed {
+		changes = append(changes, c)
+	}
+
+	if c, changed := sizeChange("disk_quota", declared.DiskQuota, process.DiskInMB); changed {
+		changes = append(changes, c)
+	}
+
+	if declared.HealthCheckType != "" && healthCheckType(declared.HealthCheckType) != healthCheckType(process.HealthCheck.Type) {
+		changes = append(changes, attributeChange{"health-check-type", declared.HealthCheckType, process.HealthCheck.Type})
+	}
+
+	if declared.HealthCheckHTTPEndpoint != "" {
+		actual := ""
+		if process.HealthCheck.Data.Endpoint != nil {
+			actual = *process.HealthCheck.Data.Endpoint
+		}
+		if actual != declared.HealthCheckHTTPEndpoint {
+			changes = append(changes, attributeChange{"health-check-http-endpoint", declared.HealthCheckHTTPEndpoint, actual})
+		}
+	}
+
+	return changes
+}
+
+func sizeChange(attribute, declared string, actualMB int64) (attributeChange, bool) {
+	if declared == "" {
+		return attributeChange{}, false
+	}
+
+	expectedMB, err := manifest.SizeInMB(declared)
+	if err != nil || expectedMB == actualMB {
+		return attributeChange{}, false
+	}
+
+	return attributeChange{attribute, declared, fmt.Sprintf("%dM", actualMB)}, true
+}
+
+// healthCheckType treats `none` as the older name for `process`.
+func healthCheckType(t string) string {
+	if t == "none" {
+		return "process"
+	}
+	return t
+}
+
+const (
+	RuleMissingProcess    = "missing-process"
+	RuleChangedProcess    = "changed-process"
+	RuleUnexpectedProcess = "unexpected-process"
+	RuleProcesses         = "processes"
+
+	webProcess = "web"
+)
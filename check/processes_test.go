@@ -0,0 +1,192 @@
+package check_test
+
+import (
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compare Processes", func() {
+	var processes []cc.Process
+
+	stringPointer := func(s string) *string {
+		return &s
+	}
+
+	BeforeEach(func() {
+		web := cc.Process{Type: "web", Command: stringPointer("bundle exec rackup"), Instances: 2, MemoryInMB: 512}
+		web.HealthCheck.Type = "http"
+		web.HealthCheck.Data.Endpoint = stringPointer("/health")
+
+		worker := cc.Process{Type: "worker", Command: stringPointer("bundle exec sidekiq"), Instances: 1, MemoryInMB: 1024}
+		worker.HealthCheck.Type = "none"
+
+		clock := cc.Process{Type: "clock", Command: stringPointer("bundle exec clockwork"), Instances: 1, MemoryInMB: 256}
+
+		processes = []cc.Process{web, worker, clock}
+	})
+
+	It("has no findings when processes match the manifest", func() {
+		app, err := manifest.LoadApplication("../fixtures/processes-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(CompareProcesses("manifest.yml", app, processes)).To(BeEmpty())
+	})
+
+	It("points changed attributes at the offending key", func() {
+		processes[1].Instances = 3
+		processes[1].MemoryInMB = 2048
+
+		app, err := manifest.LoadApplication("../fixtures/processes-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		findings := CompareProcesses("manifest.yml", app, processes)
+		Expect(findings).To(Equal([]Finding{
+			{
+				App:       "app-name",
+				Key:       "worker",
+				Rule:      RuleChangedProcess,
+				Severity:  SeverityError,
+				File:      "manifest.yml",
+				Line:      13,
+				Message:   "App 'app-name' process 'worker' has instances 3, the manifest declares 1",
+				Attribute: "instances",
+				Expected:  "1",
+				Actual:    "3",
+				Remediation: &Remediation{Ops: []manifest.Op{
+					{Type: manifest.OpReplace, Path: "/applications/name=app-name/processes/type=worker/instances", Value: 3},
+				}},
+			},
+			{
+				App:       "app-name",
+				Key:       "worker",
+				Rule:      RuleChangedProcess,
+				Severity:  SeverityError,
+				File:      "manifest.yml",
+				Line:      14,
+				Message:   "App 'app-name' process 'worker' has memory 2048M, the manifest declares 1G",
+				Attribute: "memory",
+				Expected:  "1G",
+				Actual:    "2048M",
+				Remediation: &Remediation{Ops: []manifest.Op{
+					{Type: manifest.OpReplace, Path: "/applications/name=app-name/processes/type=worker/memory", Value: "2048M"},
+				}},
+			},
+		}))
+	})
+
+	It("doesn't print commands", func() {
+		processes[0].Command = stringPointer("bundle exec puma --secret")
+
+		app, err := manifest.LoadApplication("../fixtures/processes-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		findings := CompareProcesses("manifest.yml", app, processes)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Message).To(Equal("App 'app-name' process 'web' has a command different from the manifest"))
+		Expect(findings[0].Line).To(Equal(6))
+	})
+
+	It("reports declared processes the app doesn't run", func() {
+		app, err := manifest.LoadApplication("../fixtures/processes-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		findings := CompareProcesses("manifest.yml", app, processes[:2])
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RuleMissingProcess))
+		Expect(findings[0].Message).To(Equal("App 'app-name' has no 'clock' process, which the manifest declares"))
+		Expect(findings[0].Line).To(Equal(16))
+	})
+
+	It("reports process types the manifest doesn't declare", func() {
+		processes = append(processes, cc.Process{Type: "scheduler", Instances: 1})
+
+		app, err := manifest.LoadApplication("../fixtures/processes-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		findings := CompareProcesses("manifest.yml", app, processes)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RuleUnexpectedProcess))
+		Expect(findings[0].Key).To(Equal("scheduler"))
+		Expect(findings[0].Line).To(Equal(4))
+	})
+
+	It("ignores undeclared process types with no instances", func() {
+		processes = append(processes, cc.Process{Type: "rake"}, cc.Process{Type: "console"})
+
+		app, err := manifest.LoadApplication("../fixtures/processes-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(CompareProcesses("manifest.yml", app, processes)).To(BeEmpty())
+	})
+
+	It("suggests declaring undeclared process types, or scaling them to zero", func() {
+		processes = append(processes, cc.Process{Type: "scheduler", Instances: 2})
+
+		app, err := manifest.LoadApplication("../fixtures/processes-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		findings := CompareProcesses("manifest.yml", app, processes)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Remediation).To(Equal(&Remediation{
+			Manifest: "processes:\n- type: scheduler\n  instances: 2",
+			Ops: []manifest.Op{
+				{Type: manifest.OpReplace, Path: "/applications/name=app-name/processes?/-", Value: map[string]interface{}{"type": "scheduler", "instances": 2}},
+			},
+			Command: "cf scale app-name --process scheduler -i 0",
+		}))
+	})
+
+	Context("manifest without processes", func() {
+		BeforeEach(func() {
+			processes[0].Instances = 1
+			processes[0].MemoryInMB = 256
+		})
+
+		It("only expects a web process", func() {
+			app, err := manifest.LoadApplication("../fixtures/manifest.yml", "app-name")
+			Expect(err).ToNot(HaveOccurred())
+
+			findings := CompareProcesses("manifest.yml", app, processes)
+			Expect(findings).To(HaveLen(2))
+			Expect(findings[0].Key).To(Equal("clock"))
+			Expect(findings[1].Key).To(Equal("worker"))
+			Expect(findings[1].Line).To(Equal(3))
+		})
+
+		It("compares the app's own attributes with the web process", func() {
+			processes[0].Instances = 2
+
+			app, err := manifest.LoadApplication("../fixtures/manifest.yml", "app-name")
+			Expect(err).ToNot(HaveOccurred())
+
+			findings := CompareProcesses("manifest.yml", app, processes[:1])
+			Expect(findings).To(Equal([]Finding{
+				{
+					App:       "app-name",
+					Key:       "web",
+					Rule:      RuleChangedProcess,
+					Severity:  SeverityError,
+					File:      "manifest.yml",
+					Line:      5,
+					Message:   "App 'app-name' process 'web' has instances 2, the manifest declares 1",
+					Attribute: "instances",
+					Expected:  "1",
+					Actual:    "2",
+					Remediation: &Remediation{Ops: []manifest.Op{
+						{Type: manifest.OpReplace, Path: "/applications/name=app-name/instances", Value: 2},
+					}},
+				},
+			}))
+		})
+
+		It("doesn't report a missing web process", func() {
+			app, err := manifest.LoadApplication("../fixtures/manifest.yml", "app-name")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(CompareProcesses("manifest.yml", app, nil)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,200 @@
+package check
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/odlp/antifreeze/internal/secrets"
+	"github.com/odlp/antifreeze/manifest"
+	"gopkg.in/yaml.v2"
+)
+
+// Remediation is the two ways of resolving drift between an app and its
+// manifest: adopting the app's value in the manifest, or reverting the app
+// to the manifest.
+type Remediation struct {
+	// Manifest is a YAML snippet to paste into the app's `env:` or
+	// `services:` block.
+	Manifest string
+
+	// Ops adopt the app's value by patching the manifest, for teams who
+	// assemble their manifests from ops files.
+	Ops []manifest.Op
+
+	// Command is the cf CLI command which reverts the app. It's empty when
+	// the manifest's value can't be known, such as a pinned secret.
+	Command string
+}
+
+// envRemediation adopts an ENV var in the manifest, or reverts it with
+// command. The snippet and op have a `((KEY))` variable, to be supplied
+// with `--var` or `--vars-file`, rather than the app's value, which may be
+// a secret; RevealEnvValues puts the value in when asked to.
+func envRemediation(manifestApp manifest.YApplication, key string, command string) *Remediation {
+	r := adoptEnv(manifestApp, key, envVariable(key))
+	r.Command = command
+	return r
+}
+
+// RevealEnvValues puts the app's ENV values into the snippets and ops of
+// the findings' remediations in place of `((KEY))` variables, except for
+// values which look like secrets.
+func RevealEnvValues(findings []Finding, env map[string]interface{}) []Finding {
+	for i, f := range findings {
+		r := f.Remediation
+		variable := envVariable(f.Key)
+		if r == nil || !isEnvRule(f.Rule) || r.Manifest != envSnippet(f.Key, variable) {
+			continue
+		}
+
+		value, ok := env[f.Key]
+		if !ok || isSecretEnv(f.Key, value) {
+			continue
+		}
+
+		revealed := *r
+		revealed.Manifest = envSnippet(f.Key, value)
+		revealed.Ops = nil
+		for _, op := range r.Ops {
+			if op.Value == variable {
+				op.Value = value
+			}
+			revealed.Ops = append(revealed.Ops, op)
+		}
+		findings[i].Remediation = &revealed
+	}
+
+	return findings
+}
+
+func envVariable(key string) string {
+	return fmt.Sprintf("((%s))", key)
+}
+
+func envSnippet(key string, value interface{}) string {
+	return yamlSnippet(map[string]interface{}{"env": map[string]interface{}{key: value}})
+}
+
+func isSecretEnv(key string, value interface{}) bool {
+	_, _, ok := secrets.Detect(manifest.EnvValueString(value))
+	return ok || secrets.IsSecretName(key)
+}
+
+// pinRemediation re-pins an ENV var to the app's value. Reverting the app
+// isn't suggested, as the manifest only has the value's digest.
+func pinRemediation(manifestApp manifest.YApplication, key string, value interface{}) *Remediation {
+	return adoptEnv(manifestApp, key, pinPrefix+EnvDigest(value))
+}
+
+// typoEnvRemediation renames the manifest's key to the app's.
+func typoEnvRemediation(manifestApp manifest.YApplication, key, manifestKey string) *Remediation {
+	r := envRemediation(manifestApp, key, cfCommand("unset-env", manifestApp.Name, key))
+	r.Ops = append([]manifest.Op{{Type: manifest.OpRemove, Path: manifest.AppPath(manifestApp.Name, "env", manifestKey)}}, r.Ops...)
+	return r
+}
+
+func adoptEnv(manifestApp manifest.YApplication, key string, value interface{}) *Remediation {
+	// Without an env block, the block itself has to be marked optional for
+	// go-patch to create it.
+	path := manifest.AppPath(manifestApp.Name, "env", key+"?")
+	if manifestApp.Env == nil {
+		path = manifest.AppPath(manifestApp.Name, "env?", key)
+	}
+
+	return &Remediation{
+		Manifest: envSnippet(key, value),
+		Ops:      []manifest.Op{{Type: manifest.OpReplace, Path: path, Value: value}},
+	}
+}
+
+func serviceRemediation(manifestApp manifest.YApplication, service string) *Remediation {
+	return &Remediation{
+		Manifest: yamlSnippet(map[string]interface{}{"services": []string{service}}),
+		Ops:      []manifest.Op{{Type: manifest.OpReplace, Path: manifest.AppPath(manifestApp.Name, "services?", "-"), Value: service}},
+		Command:  cfCommand("unbind-service", manifestApp.Name, service),
+	}
+}
+
+// typoServiceRemediation replaces the manifest's service with the app's.
+func typoServiceRemediation(manifestApp manifest.YApplication, service, manifestService string) *Remediation {
+	r := serviceRemediation(manifestApp, service)
+	for i, s := range manifestApp.Services {
+		if s == manifestService {
+			r.Ops = []manifest.Op{{Type: manifest.OpReplace, Path: manifest.AppPath(manifestApp.Name, "services", strconv.Itoa(i)), Value: service}}
+		}
+	}
+	return r
+}
+
+// adoptValue sets the value at the path of tokens within the app's entry
+// to the app's value, e.g. a process's instances.
+func adoptValue(manifestApp manifest.YApplication, value interface{}, tokens ...string) *Remediation {
+	return &Remediation{Ops: []manifest.Op{{Type: manifest.OpReplace, Path: manifest.AppPath(manifestApp.Name, tokens...), Value: value}}}
+}
+
+// adoptRemoval removes what the manifest declares at the path of tokens
+// within the app's entry, which the app doesn't have.
+func adoptRemoval(manifestApp manifest.YApplication, tokens ...string) *Remediation {
+	return &Remediation{Ops: []manifest.Op{{Type: manifest.OpRemove, Path: manifest.AppPath(manifestApp.Name, tokens...)}}}
+}
+
+func yamlSnippet(v interface{}) string {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(b), "\n")
+}
+
+// cfCommand formats a cf CLI command, quoting arguments for the shell.
+func cfCommand(args ...string) string {
+	quoted := []string{"cf"}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// WriteRemediationScript writes the commands which revert each app to its
+// manifest as a shell script. ENV var and service changes only take effect
+// once an app is restaged, which is left to the reader as it restarts the
+// app.
+func WriteRemediationScript(w io.Writer, findings []Finding) error {
+	var apps []string
+	commands := map[string][]string{}
+
+	for _, f := range findings {
+		if f.Remediation == nil || f.Remediation.Command == "" {
+			continue
+		}
+		if _, ok := commands[f.App]; !ok {
+			apps = append(apps, f.App)
+		}
+		commands[f.App] = append(commands[f.App], f.Remediation.Command)
+	}
+
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintln(w, "# Reverts apps to their manifest, as suggested by antifreeze check-manifest.")
+	fmt.Fprintln(w, "set -e")
+
+	for _, app := range apps {
+		fmt.Fprintf(w, "\n# %s\n", app)
+		for _, command := range commands[app] {
+			fmt.Fprintln(w, command)
+		}
+		fmt.Fprintf(w, "# Restage to apply: %s\n", cfCommand("restage", app))
+	}
+
+	return nil
+}
@@ -0,0 +1,307 @@
+package check
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/odlp/antifreeze/manifest"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OutputText    = "text"
+	OutputGitHub  = "github"
+	OutputGitLab  = "gitlab"
+	OutputOpsFile = "ops-file"
+)
+
+// OutputFormats are the formats WriteFindings can report findings in.
+var OutputFormats = []string{OutputText, OutputGitHub, OutputGitLab, OutputOpsFile}
+
+// WriteFindings reports findings in the given output format: text for
+// people, GitHub workflow commands or a GitLab code quality report for CI,
+// or an ops file which brings the manifest in line with the apps.
+func WriteFindings(w io.Writer, output string, findings []Finding) error {
+	switch output {
+	case OutputGitHub:
+		writeGitHub(w, findings)
+	case OutputGitLab:
+		return writeGitLab(w, findings)
+	case OutputOpsFile:
+		return writeOpsFile(w, findings)
+	default:
+		writeText(w, findings)
+	}
+	return nil
+}
+
+type textSection struct {
+	rule    string
+	heading func(first Finding) string
+	bullet  func(f Finding, at string) string
+}
+
+var textSections = []textSection{
+	{
+		rule: RuleUnexpectedEnv,
+		heading: func(f Finding) string {
+			return fmt.Sprintf("App '%s' has unexpected ENV vars (missing from manifest %s:%d):", f.App, f.File, f.Line)
+		},
+		bullet: keyBullet,
+	},
+	{
+		rule: RuleChangedEnv,
+		heading: func(f Finding) string {
+			return fmt.Sprintf("App '%s' has ENV vars with values different from manifest %s:", f.App, f.File)
+		},
+		bullet: keyLineBullet,
+	},
+	{
+		rule: RuleTypoEnv,
+		heading: func(f Finding) string {
+			return fmt.Sprintf("App '%s' has ENV vars which look like a rename or typo of keys in manifest %s:", f.App, f.File)
+		},
+		bullet: typoBullet,
+	},
+	{
+		rule: RuleUnexpectedService,
+		heading: func(f Finding) string {
+			return fmt.Sprintf("App '%s' has unexpected services (missing from manifest %s:%d):", f.App, f.File, f.Line)
+		},
+		bullet: keyBullet,
+	},
+	{
+		rule: RuleTypoService,
+		heading: func(f Finding) string {
+			return fmt.Sprintf("App '%s' has services which look like a rename or typo of services in manifest %s:", f.App, f.File)
+		},
+		bullet: typoBullet,
+	},
+}
+
+func keyBullet(f Finding, at string) string {
+	return bullet(f.Key, f.Notes())
+}
+
+func keyLineBullet(f Finding, at string) string {
+	return bullet(f.Key, append([]string{at}, f.Notes()...))
+}
+
+func typoBullet(f Finding, at string) string {
+	return bullet(f.Key, append([]string{fmt.Sprintf("manifest has %s on %s", f.ManifestKey, at)}, f.Notes()...))
+}
+
+func bullet(key string, notes []string) string {
+	if len(notes) == 0 {
+		return fmt.Sprintf("- %s", key)
+	}
+	return fmt.Sprintf("- %s (%s)", key, strings.Join(notes, ", "))
+}
+
+func writeText(w io.Writer, findings []Finding) {
+	var apps []string
+
+	for _, f := range findings {
+		if findTextSection(f.Rule) == nil {
+			fmt.Fprintln(w, f)
+			writeRemediation(w, f)
+			continue
+		}
+
+		if !stringInSlice(f.App, apps) {
+			apps = append(apps, f.App)
+		}
+	}
+
+	for _, app := range apps {
+		for _, section := range textSections {
+			var group []Finding
+			for _, f := range findings {
+				if f.App == app && f.Rule == section.rule {
+					group = append(group, f)
+				}
+			}
+
+			if len(group) == 0 {
+				continue
+			}
+
+			// When a merged manifest's files each contribute to a group,
+			// the heading names them all and each key names its own.
+			first := group[0]
+			files := groupFiles(group)
+			if len(files) > 1 {
+				first.File = strings.Join(files, ", ")
+			}
+
+			fmt.Fprintf(w, "\n%s\n", section.heading(first))
+
+			for _, f := range group {
+				at := fmt.Sprintf("line %d", f.Line)
+				if len(files) > 1 {
+					at = fmt.Sprintf("%s:%d", f.File, f.Line)
+				}
+
+				fmt.Fprintln(w, section.bullet(f, at))
+				writeRemediation(w, f)
+			}
+		}
+	}
+}
+
+func groupFiles(group []Finding) (files []string) {
+	for _, f := range group {
+		if !stringInSlice(f.File, files) {
+			files = append(files, f.File)
+		}
+	}
+	return files
+}
+
+// writeRemediation indents a finding's remediation beneath its bullet.
+// Snippets for values the manifest already has replace them rather than
+// being added.
+func writeRemediation(w io.Writer, f Finding) {
+	r := f.Remediation
+	if r == nil {
+		return
+	}
+
+	if r.Manifest != "" {
+		action := "add to"
+		if f.Rule == RuleChangedEnv || f.Rule == RuleTypoEnv || f.Rule == RuleTypoService {
+			action = "replace in"
+		}
+		fmt.Fprintf(w, "  %s the manifest:\n", action)
+		for _, line := range strings.Split(r.Manifest, "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+
+	if r.Command != "" {
+		fmt.Fprintf(w, "  or revert the app: %s\n", r.Command)
+	}
+}
+
+func findTextSection(rule string) *textSection {
+	for i := range textSections {
+		if textSections[i].rule == rule {
+			return &textSections[i]
+		}
+	}
+	return nil
+}
+
+func writeGitHub(w io.Writer, findings []Finding) {
+	for _, f := range findings {
+		fmt.Fprintf(w, "::%s file=%s,line=%d,title=%s::%s\n",
+			f.Severity,
+			escapeGitHubProperty(filepath.Clean(f.File)),
+			f.Line,
+			escapeGitHubProperty(f.Rule),
+			escapeGitHubData(f.Description()),
+		)
+	}
+}
+
+var gitHubDataEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+var gitHubPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+func escapeGitHubData(s string) string {
+	return gitHubDataEscaper.Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return gitHubPropertyEscaper.Replace(s)
+}
+
+type gitLabIssue struct {
+	Description string         `json:"description"`
+	CheckName   string         `json:"check_name"`
+	Fingerprint string         `json:"fingerprint"`
+	Severity    string         `json:"severity"`
+	Location    gitLabLocation `json:"location"`
+}
+
+type gitLabLocation struct {
+	Path  string      `json:"path"`
+	Lines gitLabLines `json:"lines"`
+}
+
+type gitLabLines struct {
+	Begin int `json:"begin"`
+}
+
+// gitLabSeverities maps finding severities onto the code quality report's.
+var gitLabSeverities = map[string]string{
+	SeverityError:   "major",
+	SeverityWarning: "minor",
+}
+
+func writeGitLab(w io.Writer, findings []Finding) error {
+	issues := make([]gitLabIssue, 0, len(findings))
+
+	for _, f := range findings {
+		path := filepath.Clean(f.File)
+
+		issues = append(issues, gitLabIssue{
+			Description: f.Description(),
+			CheckName:   f.Rule,
+			Fingerprint: fingerprint(f.Rule, path, f.App, f.Key, f.Message),
+			Severity:    gitLabSeverities[f.Severity],
+			Location: gitLabLocation{
+				Path:  path,
+				Lines: gitLabLines{Begin: f.Line},
+			},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(issues)
+}
+
+// fingerprint identifies a finding across runs without depending on its
+// line, so moving a block around doesn't look like a new issue.
+func fingerprint(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeOpsFile writes the ops which adopt each app's values in the
+// manifest. Warnings without a fix to suggest, such as lint warnings or ENV
+// vars managed by the platform, are left out. Errors no op can adopt, such
+// as a switch from a buildpack to a docker image, are listed in a comment
+// at the top so they aren't mistaken for fixed.
+func writeOpsFile(w io.Writer, findings []Finding) error {
+	ops := []manifest.Op{}
+	var unadopted []Finding
+
+	for _, f := range findings {
+		if f.Remediation != nil && len(f.Remediation.Ops) > 0 {
+			ops = append(ops, f.Remediation.Ops...)
+		} else if f.Severity == SeverityError {
+			unadopted = append(unadopted, f)
+		}
+	}
+
+	if len(unadopted) > 0 {
+		fmt.Fprintln(w, "# No op adopts these findings, so they need fixing by hand:")
+		for _, f := range unadopted {
+			fmt.Fprintf(w, "# %s\n", f)
+		}
+	}
+
+	b, err := yaml.Marshal(ops)
+	if err != nil {
+		return fmt.Errorf("Unable to write ops file: %s", err)
+	}
+
+	_, err = w.Write(b)
+	return err
+}
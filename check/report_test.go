@@ -0,0 +1,274 @@
+package check_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("Write Findings", func() {
+	var findings []Finding
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		findings = []Finding{
+			{
+				App:      "app-name",
+				Rule:     "yaml-coercion",
+				Severity: SeverityWarning,
+				File:     "./manifest.yml",
+				Line:     8,
+				Message:  "ENV var 'FILE_MODE' is written as 0755 but decoded as 493 (int); quote the value to keep it as written",
+			},
+			{
+				App:      "app-name",
+				Key:      "SNOW_FLAKE_VAR",
+				Rule:     RuleUnexpectedEnv,
+				Severity: SeverityError,
+				File:     "./manifest.yml",
+				Line:     7,
+				Message:  "App 'app-name' has unexpected ENV var 'SNOW_FLAKE_VAR' (missing from manifest)",
+			},
+			{
+				App:      "app-name",
+				Key:      "ENV_VAR_2",
+				Rule:     RuleChangedEnv,
+				Severity: SeverityError,
+				File:     "./manifest.yml",
+				Line:     9,
+				Message:  "App 'app-name' has ENV var 'ENV_VAR_2' with a value different from the manifest",
+			},
+			{
+				App:      "app-name",
+				Key:      "surprise-service",
+				Rule:     RuleUnexpectedService,
+				Severity: SeverityError,
+				File:     "./manifest.yml",
+				Line:     4,
+				Message:  "App 'app-name' has unexpected service 'surprise-service' (missing from manifest)",
+			},
+		}
+	})
+
+	It("writes text grouped by app", func() {
+		Expect(WriteFindings(out, OutputText, findings)).To(Succeed())
+		Expect(out.String()).To(Equal(`./manifest.yml:8: warning: ENV var 'FILE_MODE' is written as 0755 but decoded as 493 (int); quote the value to keep it as written
+
+App 'app-name' has unexpected ENV vars (missing from manifest ./manifest.yml:7):
+- SNOW_FLAKE_VAR
+
+App 'app-name' has ENV vars with values different from manifest ./manifest.yml:
+- ENV_VAR_2 (line 9)
+
+App 'app-name' has unexpected services (missing from manifest ./manifest.yml:4):
+- surprise-service
+`))
+	})
+
+	It("writes possible typos alongside the manifest key", func() {
+		typo := Finding{
+			App:         "app-name",
+			Key:         "DATABASE_URL",
+			ManifestKey: "DATABSE_URL",
+			Rule:        RuleTypoEnv,
+			Severity:    SeverityError,
+			File:        "./manifest.yml",
+			Line:        10,
+		}
+
+		Expect(WriteFindings(out, OutputText, []Finding{typo})).To(Succeed())
+		Expect(out.String()).To(Equal(`
+App 'app-name' has ENV vars which look like a rename or typo of keys in manifest ./manifest.yml:
+- DATABASE_URL (manifest has DATABSE_URL on line 10)
+`))
+	})
+
+	It("names the file of each key when a group spans merged manifests", func() {
+		changed := findings[2]
+		changed.Key = "ENV_VAR_3"
+		changed.File = "./prod.yml"
+		changed.Line = 12
+
+		Expect(WriteFindings(out, OutputText, []Finding{findings[2], changed})).To(Succeed())
+		Expect(out.String()).To(Equal(`
+App 'app-name' has ENV vars with values different from manifest ./manifest.yml, ./prod.yml:
+- ENV_VAR_2 (./manifest.yml:9)
+- ENV_VAR_3 (./prod.yml:12)
+`))
+	})
+
+	It("writes who last made a matching change", func() {
+		findings[1].Actor = "jane@example.com"
+		findings[1].ChangedAt = time.Date(2017, 7, 23, 18, 57, 36, 0, time.UTC)
+
+		Expect(WriteFindings(out, OutputText, findings[1:2])).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("- SNOW_FLAKE_VAR (last changed by jane@example.com at 2017-07-23T18:57:36Z)\n"))
+
+		out.Reset()
+		Expect(WriteFindings(out, OutputGitHub, findings[1:2])).To(Succeed())
+		Expect(out.String()).To(HaveSuffix("(missing from manifest) (last changed by jane@example.com at 2017-07-23T18:57:36Z)\n"))
+	})
+
+	It("writes where ENV vars come from ahead of who changed them", func() {
+		findings[1].Source = EnvSourceUserProvided
+		findings[1].Actor = "jane@example.com"
+		findings[1].ChangedAt = time.Date(2017, 7, 23, 18, 57, 36, 0, time.UTC)
+
+		Expect(WriteFindings(out, OutputText, findings[1:2])).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("- SNOW_FLAKE_VAR (from user-provided env, last changed by jane@example.com at 2017-07-23T18:57:36Z)\n"))
+	})
+
+	It("writes who last changed findings which aren't grouped by app", func() {
+		process := Finding{
+			App:       "app-name",
+			Key:       "web",
+			Rule:      RuleChangedProcess,
+			Severity:  SeverityError,
+			File:      "./manifest.yml",
+			Line:      5,
+			Message:   "App 'app-name' has 3 web instances, the manifest declares 2",
+			Actor:     "jane@example.com",
+			ChangedAt: time.Date(2017, 7, 23, 18, 57, 36, 0, time.UTC),
+		}
+
+		Expect(WriteFindings(out, OutputText, []Finding{process})).To(Succeed())
+		Expect(out.String()).To(Equal("./manifest.yml:5: error: App 'app-name' has 3 web instances, the manifest declares 2 (last changed by jane@example.com at 2017-07-23T18:57:36Z)\n"))
+	})
+
+	It("writes how to fix each finding beneath it", func() {
+		findings[1].Remediation = &Remediation{
+			Manifest: "env:\n  SNOW_FLAKE_VAR: ((SNOW_FLAKE_VAR))",
+			Command:  "cf unset-env app-name SNOW_FLAKE_VAR",
+		}
+
+		Expect(WriteFindings(out, OutputText, findings[1:2])).To(Succeed())
+		Expect(out.String()).To(Equal(`
+App 'app-name' has unexpected ENV vars (missing from manifest ./manifest.yml:7):
+- SNOW_FLAKE_VAR
+  add to the manifest:
+    env:
+      SNOW_FLAKE_VAR: ((SNOW_FLAKE_VAR))
+  or revert the app: cf unset-env app-name SNOW_FLAKE_VAR
+`))
+	})
+
+	It("replaces values the manifest already has", func() {
+		findings[2].Remediation = &Remediation{
+			Manifest: "env:\n  ENV_VAR_2: ((ENV_VAR_2))",
+			Command:  "cf set-env app-name ENV_VAR_2 https://pivotal.io",
+		}
+
+		Expect(WriteFindings(out, OutputText, findings[2:3])).To(Succeed())
+		Expect(out.String()).To(ContainSubstring(`
+- ENV_VAR_2 (line 9)
+  replace in the manifest:
+    env:
+      ENV_VAR_2: ((ENV_VAR_2))
+  or revert the app: cf set-env app-name ENV_VAR_2 https://pivotal.io
+`))
+	})
+
+	It("writes an ops file adopting each app's values", func() {
+		findings[1].Remediation = &Remediation{
+			Ops: []manifest.Op{{Type: manifest.OpReplace, Path: "/applications/name=app-name/env/SNOW_FLAKE_VAR?", Value: "((SNOW_FLAKE_VAR))"}},
+		}
+		findings[3].Remediation = &Remediation{
+			Ops: []manifest.Op{{Type: manifest.OpReplace, Path: "/applications/name=app-name/services?/-", Value: "surprise-service"}},
+		}
+
+		Expect(WriteFindings(out, OutputOpsFile, findings)).To(Succeed())
+		Expect(out.String()).To(Equal(`# No op adopts these findings, so they need fixing by hand:
+# ./manifest.yml:9: error: App 'app-name' has ENV var 'ENV_VAR_2' with a value different from the manifest
+- type: replace
+  path: /applications/name=app-name/env/SNOW_FLAKE_VAR?
+  value: ((SNOW_FLAKE_VAR))
+- type: replace
+  path: /applications/name=app-name/services?/-
+  value: surprise-service
+`))
+	})
+
+	It("writes ops adopting process, sidecar, metadata and docker drift", func() {
+		processes, err := manifest.LoadApplication("../fixtures/processes-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+		web := cc.Process{Type: "web", Instances: 4, MemoryInMB: 512}
+		drift := CompareProcesses("manifest.yml", processes, []cc.Process{web})
+
+		sidecars, err := manifest.LoadApplication("../fixtures/sidecars-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+		drift = append(drift, CompareSidecars("manifest.yml", sidecars, []cc.Sidecar{{Name: "logger", Command: "./logger"}})...)
+
+		metadata, err := manifest.LoadApplication("../fixtures/metadata-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+		team := "billing"
+		drift = append(drift, CompareMetadata("manifest.yml", metadata, cc.Metadata{Labels: map[string]*string{"team": &team}}, nil)...)
+
+		docker, err := manifest.LoadApplication("../fixtures/docker-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+		drift = append(drift, CompareDocker("manifest.yml", docker, "buildpack", cc.Package{})...)
+
+		Expect(WriteFindings(out, OutputOpsFile, drift)).To(Succeed())
+
+		var unadopted []string
+		var ops []manifest.Op
+		for _, line := range strings.Split(out.String(), "\n") {
+			if strings.HasPrefix(line, "# manifest.yml") {
+				unadopted = append(unadopted, line)
+			}
+		}
+		Expect(yaml.Unmarshal(out.Bytes(), &ops)).To(Succeed())
+
+		var paths []string
+		for _, op := range ops {
+			paths = append(paths, op.Type+" "+op.Path)
+		}
+		Expect(paths).To(ContainElement("replace /applications/name=app-name/processes/type=web/instances"))
+		Expect(paths).To(ContainElement("remove /applications/name=app-name/processes/type=worker"))
+		Expect(paths).To(ContainElement("replace /applications/name=app-name/sidecars?/-"))
+		Expect(paths).To(ContainElement("replace /applications/name=app-name/metadata/labels/team"))
+		Expect(paths).To(ContainElement("remove /applications/name=app-name/metadata/labels/cost-centre"))
+		Expect(unadopted).To(ConsistOf(ContainSubstring("error: App 'app-name' runs from a buildpack")))
+	})
+
+	It("writes an empty ops file when there's nothing to adopt", func() {
+		Expect(WriteFindings(out, OutputOpsFile, findings[:1])).To(Succeed())
+		Expect(out.String()).To(Equal("[]\n"))
+	})
+
+	It("writes GitHub workflow commands", func() {
+		Expect(WriteFindings(out, OutputGitHub, findings[:2])).To(Succeed())
+		Expect(out.String()).To(Equal(`::warning file=manifest.yml,line=8,title=yaml-coercion::ENV var 'FILE_MODE' is written as 0755 but decoded as 493 (int); quote the value to keep it as written
+::error file=manifest.yml,line=7,title=unexpected-env::App 'app-name' has unexpected ENV var 'SNOW_FLAKE_VAR' (missing from manifest)
+`))
+	})
+
+	It("writes a GitLab code quality report", func() {
+		Expect(WriteFindings(out, OutputGitLab, findings[1:2])).To(Succeed())
+
+		var issues []map[string]interface{}
+		Expect(json.Unmarshal(out.Bytes(), &issues)).To(Succeed())
+		Expect(issues).To(HaveLen(1))
+		Expect(issues[0]["description"]).To(Equal("App 'app-name' has unexpected ENV var 'SNOW_FLAKE_VAR' (missing from manifest)"))
+		Expect(issues[0]["check_name"]).To(Equal("unexpected-env"))
+		Expect(issues[0]["severity"]).To(Equal("major"))
+		Expect(issues[0]["fingerprint"]).To(HaveLen(64))
+		Expect(issues[0]["location"]).To(Equal(map[string]interface{}{
+			"path":  "manifest.yml",
+			"lines": map[string]interface{}{"begin": float64(7)},
+		}))
+	})
+
+	It("writes an empty GitLab report when there are no findings", func() {
+		Expect(WriteFindings(out, OutputGitLab, nil)).To(Succeed())
+		Expect(out.String()).To(Equal("[]\n"))
+	})
+})
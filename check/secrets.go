@@ -0,0 +1,49 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/internal/secrets"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+// SniffSecrets scans the app's ENV values for credentials pasted in with
+// `cf set-env`, which are better kept in a user-provided service or CredHub.
+// Findings only show masked fragments of the values.
+func SniffSecrets(manifestPath string, manifestApp manifest.YApplication, app plugin_models.GetAppModel) (findings []Finding) {
+	var keys []string
+	for k := range app.EnvironmentVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value := manifest.EnvValueString(app.EnvironmentVars[k])
+
+		kind, fragment, ok := secrets.Detect(value)
+		if !ok {
+			continue
+		}
+
+		line, ok := manifestApp.Lines.EnvKeys[k]
+		if !ok {
+			line = blockLine(manifestApp.Lines.Env, manifestApp.Lines)
+		}
+
+		findings = append(findings, Finding{
+			App:      manifestApp.Name,
+			Key:      k,
+			Rule:     RulePossibleSecret,
+			Severity: SeverityWarning,
+			File:     manifestPath,
+			Line:     line,
+			Message:  fmt.Sprintf("App '%s' has ENV var '%s' which looks like %s (%s); move it into a user-provided service or CredHub", manifestApp.Name, k, kind, secrets.Mask(fragment)),
+		})
+	}
+
+	return findings
+}
+
+const RulePossibleSecret = "possible-secret"
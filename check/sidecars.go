@@ -0,0 +1,159 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+// CheckSidecars compares the sidecars an app runs with those its manifest
+// declares.
+func CheckSidecars(client *cc.Client, appGUID, manifestPath string, manifestApp manifest.YApplication) ([]Finding, error) {
+	sidecars, err := client.GetSidecars(appGUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CompareSidecars(manifestPath, manifestApp, sidecars), nil
+}
+
+// CompareSidecars reports sidecars added to or removed from the app, and
+// declared attributes which differ. Sidecars provided by a buildpack aren't
+// declared in manifests, so they're left out.
+func CompareSidecars(manifestPath string, manifestApp manifest.YApplication, sidecars []cc.Sidecar) (findings []Finding) {
+	live := map[string]cc.Sidecar{}
+	for _, s := range sidecars {
+		if s.Origin != buildpackOrigin {
+			live[s.Name] = s
+		}
+	}
+
+	declared := map[string]bool{}
+
+	for _, d := range manifestApp.Sidecars {
+		declared[d.Name] = true
+		lines := manifestApp.Lines.Sidecars[d.Name]
+		sidecar, ok := live[d.Name]
+
+		if !ok {
+			findings = append(findings, Finding{
+				App:      manifestApp.Name,
+				Key:      d.Name,
+				Rule:     RuleMissingSidecar,
+				Severity: SeverityError,
+				File:     manifestPath,
+				Line:     lines.Line,
+				Message:  fmt.Sprintf("App '%s' has no '%s' sidecar, which the manifest declares", manifestApp.Name, d.Name),
+
+				Remediation: adoptRemoval(manifestApp, "sidecars", "name="+d.Name),
+			})
+			continue
+		}
+
+		for _, c := range sidecarChanges(d, sidecar) {
+			message := fmt.Sprintf("App '%s' sidecar '%s' has %s %s, the manifest declares %s", manifestApp.Name, d.Name, c.attribute, c.actual, c.expected)
+			if c.attribute == "command" {
+				message = fmt.Sprintf("App '%s' sidecar '%s' has a command different from the manifest", manifestApp.Name, d.Name)
+			}
+
+			var value interface{} = c.actual
+			if c.attribute == "process_types" {
+				value = sortedCopy(sidecar.ProcessTypes)
+			}
+
+			findings = append(findings, Finding{
+				App:       manifestApp.Name,
+				Key:       d.Name,
+				Rule:      RuleChangedSidecar,
+				Severity:  SeverityError,
+				File:      manifestPath,
+				Line:      lines.Keys[c.attribute],
+				Message:   message,
+				Attribute: c.attribute,
+				Expected:  c.expected,
+				Actual:    c.actual,
+
+				Remediation: adoptValue(manifestApp, value, "sidecars", "name="+d.Name, c.attribute),
+			})
+		}
+	}
+
+	var names []string
+	for name := range live {
+		if !declared[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		findings = append(findings, Finding{
+			App:      manifestApp.Name,
+			Key:      name,
+			Rule:     RuleUnexpectedSidecar,
+			Severity: SeverityError,
+			File:     manifestPath,
+			Line:     blockLine(manifestApp.Lines.Keys["sidecars"], manifestApp.Lines),
+			Message:  fmt.Sprintf("App '%s' has unexpected sidecar '%s' (missing from manifest)", manifestApp.Name, name),
+
+			Remediation: adoptValue(manifestApp, declaredSidecar(live[name]), "sidecars?", "-"),
+		})
+	}
+
+	return findings
+}
+
+// declaredSidecar is a sidecar as the manifest would declare it.
+func declaredSidecar(sidecar cc.Sidecar) map[string]interface{} {
+	declared := map[string]interface{}{
+		"name":          sidecar.Name,
+		"command":       sidecar.Command,
+		"process_types": sortedCopy(sidecar.ProcessTypes),
+	}
+	if sidecar.MemoryInMB != nil {
+		declared["memory"] = fmt.Sprintf("%dM", *sidecar.MemoryInMB)
+	}
+	return declared
+}
+
+func sidecarChanges(declared manifest.YSidecar, sidecar cc.Sidecar) (changes []attributeChange) {
+	if declared.Command != "" && declared.Command != sidecar.Command {
+		changes = append(changes, attributeChange{"command", declared.Command, sidecar.Command})
+	}
+
+	if declared.ProcessTypes != nil {
+		expected := sortedCopy(declared.ProcessTypes)
+		actual := sortedCopy(sidecar.ProcessTypes)
+
+		if strings.Join(expected, ",") != strings.Join(actual, ",") {
+			changes = append(changes, attributeChange{"process_types", strings.Join(expected, ", "), strings.Join(actual, ", ")})
+		}
+	}
+
+	if sidecar.MemoryInMB != nil {
+		if c, changed := sizeChange("memory", declared.Memory, *sidecar.MemoryInMB); changed {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes
+}
+
+func sortedCopy(list []string) []string {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+const (
+	RuleMissingSidecar    = "missing-sidecar"
+	RuleChangedSidecar    = "changed-sidecar"
+	RuleUnexpectedSidecar = "unexpected-sidecar"
+	RuleSidecars          = "sidecars"
+
+	buildpackOrigin = "buildpack"
+)
@@ -0,0 +1,69 @@
+package check_test
+
+import (
+	. "github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compare Sidecars", func() {
+	var sidecars []cc.Sidecar
+	var app manifest.YApplication
+
+	BeforeEach(func() {
+		memory := int64(64)
+		sidecars = []cc.Sidecar{
+			{Name: "envoy", Command: "/etc/cnb/envoy --config envoy.yaml", ProcessTypes: []string{"worker", "web"}, MemoryInMB: &memory, Origin: "user"},
+			{Name: "log-shipper", Command: "./bin/ship-logs", ProcessTypes: []string{"web"}, Origin: "user"},
+		}
+
+		var err error
+		app, err = manifest.LoadApplication("../fixtures/sidecars-manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("has no findings when sidecars match the manifest", func() {
+		Expect(CompareSidecars("manifest.yml", app, sidecars)).To(BeEmpty())
+	})
+
+	It("reports changed sidecars at the offending key", func() {
+		sidecars[0].ProcessTypes = []string{"web"}
+		sidecars[1].Command = "./bin/ship-logs --verbose"
+
+		findings := CompareSidecars("manifest.yml", app, sidecars)
+		Expect(findings).To(HaveLen(2))
+
+		Expect(findings[0].Rule).To(Equal(RuleChangedSidecar))
+		Expect(findings[0].Message).To(Equal("App 'app-name' sidecar 'envoy' has process_types web, the manifest declares web, worker"))
+		Expect(findings[0].Line).To(Equal(7))
+
+		Expect(findings[1].Message).To(Equal("App 'app-name' sidecar 'log-shipper' has a command different from the manifest"))
+		Expect(findings[1].Line).To(Equal(12))
+	})
+
+	It("reports removed sidecars", func() {
+		findings := CompareSidecars("manifest.yml", app, sidecars[:1])
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RuleMissingSidecar))
+		Expect(findings[0].Key).To(Equal("log-shipper"))
+		Expect(findings[0].Line).To(Equal(11))
+	})
+
+	It("reports added sidecars", func() {
+		sidecars = append(sidecars, cc.Sidecar{Name: "debugger", Origin: "user"})
+
+		findings := CompareSidecars("manifest.yml", app, sidecars)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RuleUnexpectedSidecar))
+		Expect(findings[0].Message).To(Equal("App 'app-name' has unexpected sidecar 'debugger' (missing from manifest)"))
+		Expect(findings[0].Line).To(Equal(4))
+	})
+
+	It("ignores sidecars provided by buildpacks", func() {
+		sidecars = append(sidecars, cc.Sidecar{Name: "apm-agent", Origin: "buildpack"})
+
+		Expect(CompareSidecars("manifest.yml", app, sidecars)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,141 @@
+// Package git reads manifests as they were at a revision of the local git
+// repository, straight from its object store rather than a checkout, and
+// finds the commit which last changed a line.
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Revision is a resolved commit in the local git repository.
+type Revision struct {
+	// Ref is the revision as given, such as a tag or branch.
+	Ref     string
+	Commit  string
+	Summary string
+}
+
+// Commit is the commit which last changed a line.
+type Commit struct {
+	Hash    string
+	Author  string
+	Summary string
+}
+
+// ShortHash abbreviates a commit hash the way git does by default.
+func ShortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+func (r Revision) String() string {
+	if r.Ref == r.Commit || strings.HasPrefix(r.Commit, r.Ref) {
+		return fmt.Sprintf("%s (%s)", ShortHash(r.Commit), r.Summary)
+	}
+	return fmt.Sprintf("%s, commit %s (%s)", r.Ref, ShortHash(r.Commit), r.Summary)
+}
+
+// Resolve finds the commit a ref points at, in the repository containing
+// dir.
+func Resolve(dir, ref string) (Revision, error) {
+	commit, err := run(dir, "rev-parse", "--verify", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		return Revision{}, fmt.Errorf("Unable to find git revision '%s': %s", ref, err)
+	}
+
+	summary, err := run(dir, "log", "-1", "--format=%s", commit)
+	if err != nil {
+		return Revision{}, fmt.Errorf("Unable to find git revision '%s': %s", ref, err)
+	}
+
+	return Revision{Ref: ref, Commit: commit, Summary: summary}, nil
+}
+
+// ReadFile reads a file as it was at the revision. The path is relative to
+// the working directory, as it would be for the file in a checkout.
+func (r Revision) ReadFile(path string) ([]byte, error) {
+	cmd := exec.Command("git", "-C", filepath.Dir(path), "cat-file", "blob", r.Commit+":./"+filepath.Base(path))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s at %s: %s", path, r.Ref, gitError(err, stderr))
+	}
+	return out, nil
+}
+
+// Blame finds the commit which last changed a line of a file, as of the
+// revision, or as the file is now when revision is nil. Uncommitted changes
+// have a zero hash.
+func Blame(revision *Revision, path string, line int) (Commit, error) {
+	args := []string{"blame", "--porcelain", "-L", fmt.Sprintf("%d,%d", line, line)}
+	if revision != nil {
+		args = append(args, revision.Commit)
+	}
+	args = append(args, "--", filepath.Base(path))
+
+	out, err := run(filepath.Dir(path), args...)
+	if err != nil {
+		return Commit{}, fmt.Errorf("Unable to blame %s:%d: %s", path, line, err)
+	}
+
+	return parseBlame(out), nil
+}
+
+// parseBlame reads the commit from `git blame --porcelain` output: the
+// hash, then headers such as `author` and `summary`.
+func parseBlame(out string) (commit Commit) {
+	for i, line := range strings.Split(out, "\n") {
+		if i == 0 {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				commit.Hash = fields[0]
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "\t") {
+			break
+		}
+
+		header := strings.SplitN(line, " ", 2)
+		if len(header) < 2 {
+			continue
+		}
+
+		switch header[0] {
+		case "author":
+			commit.Author = header[1]
+		case "summary":
+			commit.Summary = header[1]
+		}
+	}
+	return commit
+}
+
+func run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", gitError(err, stderr)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func gitError(err error, stderr bytes.Buffer) error {
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return fmt.Errorf("%s", message)
+	}
+	return err
+}
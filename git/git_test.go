@@ -0,0 +1,77 @@
+package git_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/odlp/antifreeze/git"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Git", func() {
+	var dir, manifestPath string
+
+	gitIn := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Jane", "-c", "user.email=jane@example.com", "-c", "commit.gpgsign=false", "-c", "tag.gpgsign=false"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		Expect(err).ToNot(HaveOccurred(), string(out))
+	}
+
+	commit := func(contents, message string) {
+		Expect(ioutil.WriteFile(manifestPath, []byte(contents), 0644)).To(Succeed())
+		gitIn("add", "manifest.yml")
+		gitIn("commit", "-q", "-m", message)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "antifreeze-git")
+		Expect(err).ToNot(HaveOccurred())
+		manifestPath = filepath.Join(dir, "manifest.yml")
+
+		gitIn("init", "-q")
+		commit("applications:\n- name: app-name\n  env:\n    ENV_VAR_1: one\n", "Add app-name")
+		gitIn("tag", "v1.4.2")
+		commit("applications:\n- name: app-name\n  env:\n    ENV_VAR_1: two\n", "Bump ENV_VAR_1")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reads a file as it was at a revision", func() {
+		revision, err := Resolve(dir, "v1.4.2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(revision.Ref).To(Equal("v1.4.2"))
+		Expect(revision.Summary).To(Equal("Add app-name"))
+		Expect(revision.String()).To(Equal("v1.4.2, commit " + ShortHash(revision.Commit) + " (Add app-name)"))
+
+		b, err := revision.ReadFile(manifestPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(ContainSubstring("ENV_VAR_1: one"))
+	})
+
+	It("rejects a revision which doesn't exist", func() {
+		_, err := Resolve(dir, "v9.9.9")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("Unable to find git revision 'v9.9.9': "))
+	})
+
+	It("finds the commit which last changed a line", func() {
+		commit, err := Blame(nil, manifestPath, 4)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(commit.Author).To(Equal("Jane"))
+		Expect(commit.Summary).To(Equal("Bump ENV_VAR_1"))
+
+		revision, err := Resolve(dir, "v1.4.2")
+		Expect(err).ToNot(HaveOccurred())
+
+		commit, err = Blame(&revision, manifestPath, 4)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(commit.Hash).To(Equal(revision.Commit))
+		Expect(commit.Summary).To(Equal("Add app-name"))
+	})
+})
@@ -0,0 +1,13 @@
+package cc_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCC(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CC Suite")
+}
@@ -0,0 +1,347 @@
+// Package cc is a small client for the Cloud Controller API, covering the
+// parts of an app which the cf CLI's plugin RPC interface doesn't expose.
+// It mostly uses the v3 API, falling back to the v2 API for what v3 can't
+// list in bulk.
+package cc
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Connection supplies the API endpoint and access token. A cf CLI plugin
+// connection satisfies it, and refreshes an expired token when asked for
+// one.
+type Connection interface {
+	ApiEndpoint() (string, error)
+	AccessToken() (string, error)
+}
+
+// sslDisabler is implemented by connections which know whether the target
+// was set with --skip-ssl-validation.
+type sslDisabler interface {
+	IsSSLDisabled() (bool, error)
+}
+
+type Client struct {
+	connection Connection
+	httpClient *http.Client
+	endpoint   string
+	token      string
+	retries    int
+	backoff    time.Duration
+}
+
+func NewClient(connection Connection) *Client {
+	return &Client{
+		connection: connection,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// SetHTTPClient replaces the HTTP client used for requests, e.g. to record
+// them or to add timeouts.
+func (c *Client) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
+}
+
+// SetTimeout limits how long each request may take.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
+}
+
+// SetRetries retries requests which fail with a transient error, such as a
+// 503 or a dropped connection, up to retries times. The wait between
+// attempts starts at backoff and doubles each time.
+func (c *Client) SetRetries(retries int, backoff time.Duration) {
+	c.retries = retries
+	c.backoff = backoff
+}
+
+// Error is an error response from the Cloud Controller.
+type Error struct {
+	StatusCode int
+	Title      string
+	Detail     string
+}
+
+func (e Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("Cloud Controller responded with status %d", e.StatusCode)
+	}
+	return e.Detail
+}
+
+// IsNotFound reports whether err is a Cloud Controller 404.
+func IsNotFound(err error) bool {
+	ccErr, ok := err.(Error)
+	return ok && ccErr.StatusCode == http.StatusNotFound
+}
+
+type link struct {
+	Href string `json:"href"`
+}
+
+type page struct {
+	Pagination struct {
+		Next *link `json:"next"`
+	} `json:"pagination"`
+	Resources []json.RawMessage            `json:"resources"`
+	Included  map[string][]json.RawMessage `json:"included"`
+}
+
+// v2Page is a page of results from the v2 API, whose pagination links are
+// paths rather than URLs.
+type v2Page struct {
+	NextURL   *string           `json:"next_url"`
+	Resources []json.RawMessage `json:"resources"`
+}
+
+// get fetches a single resource into out.
+func (c *Client) get(path string, query url.Values, out interface{}) error {
+	target, err := c.url(path, query)
+	if err != nil {
+		return err
+	}
+	return c.do(target, out)
+}
+
+// list follows pagination links and decodes every resource into out, which
+// must be a pointer to a slice.
+func (c *Client) list(path string, query url.Values, out interface{}) error {
+	return c.listIncluding(path, query, out, "", nil)
+}
+
+// listIncluding is list for requests with an `include` parameter, which
+// also decodes the included resources of one kind into included.
+func (c *Client) listIncluding(path string, query url.Values, out interface{}, kind string, included interface{}) error {
+	target, err := c.url(path, query)
+	if err != nil {
+		return err
+	}
+
+	var resources, includedResources []json.RawMessage
+
+	for target != "" {
+		var p page
+		if err := c.do(target, &p); err != nil {
+			return err
+		}
+
+		resources = append(resources, p.Resources...)
+		includedResources = append(includedResources, p.Included[kind]...)
+
+		target = ""
+		if p.Pagination.Next != nil {
+			target = p.Pagination.Next.Href
+		}
+	}
+
+	if included != nil {
+		if err := decodeResources(includedResources, included); err != nil {
+			return err
+		}
+	}
+
+	return decodeResources(resources, out)
+}
+
+// listV2 is list for the v2 API.
+func (c *Client) listV2(path string, query url.Values, out interface{}) error {
+	target, err := c.url(path, query)
+	if err != nil {
+		return err
+	}
+
+	var resources []json.RawMessage
+
+	for target != "" {
+		var p v2Page
+		if err := c.do(target, &p); err != nil {
+			return err
+		}
+
+		resources = append(resources, p.Resources...)
+
+		target = ""
+		if p.NextURL != nil && *p.NextURL != "" {
+			target = c.endpoint + *p.NextURL
+		}
+	}
+
+	return decodeResources(resources, out)
+}
+
+func decodeResources(resources []json.RawMessage, out interface{}) error {
+	if resources == nil {
+		resources = []json.RawMessage{}
+	}
+
+	b, err := json.Marshal(resources)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (c *Client) url(path string, query url.Values) (string, error) {
+	if c.endpoint == "" {
+		endpoint, err := c.connection.ApiEndpoint()
+		if err != nil {
+			return "", fmt.Errorf("Unable to get API endpoint: %s", err)
+		}
+
+		if disabler, ok := c.connection.(sslDisabler); ok {
+			if disabled, err := disabler.IsSSLDisabled(); err == nil && disabled {
+				c.skipSSLValidation()
+			}
+		}
+
+		c.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+
+	target := c.endpoint + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target, nil
+}
+
+func (c *Client) skipSSLValidation() {
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if c.httpClient.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport).Clone(), true
+	}
+
+	if !ok {
+		return
+	}
+
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	c.httpClient.Transport = transport
+}
+
+// do makes a GET request, fetching a fresh token and retrying once when the
+// current one has been rejected.
+func (c *Client) do(target string, out interface{}) error {
+	if c.token == "" {
+		if err := c.refreshToken(); err != nil {
+			return err
+		}
+	}
+
+	status, body, err := c.sendWithRetries(target)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusUnauthorized {
+		if err := c.refreshToken(); err != nil {
+			return err
+		}
+
+		status, body, err = c.sendWithRetries(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	if status >= 400 {
+		return parseError(status, body)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("Unable to parse response from %s: %s", target, err)
+	}
+	return nil
+}
+
+func (c *Client) sendWithRetries(target string) (status int, body []byte, err error) {
+	wait := c.backoff
+
+	for attempt := 0; ; attempt++ {
+		status, body, err = c.send(target)
+		if attempt >= c.retries || !isTransient(status, err) {
+			return status, body, err
+		}
+
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// isTransient reports whether a request may succeed if it's made again.
+func isTransient(status int, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) send(target string) (int, []byte, error) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req.Header.Set("Authorization", c.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Unable to reach the Cloud Controller: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Unable to read response from %s: %s", target, err)
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+func (c *Client) refreshToken() error {
+	token, err := c.connection.AccessToken()
+	if err != nil {
+		return fmt.Errorf("Unable to get access token: %s", err)
+	}
+
+	if !strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = "bearer " + token
+	}
+
+	c.token = token
+	return nil
+}
+
+func parseError(status int, body []byte) error {
+	var response struct {
+		Errors []struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+
+	ccErr := Error{StatusCode: status}
+
+	if json.Unmarshal(body, &response) == nil && len(response.Errors) > 0 {
+		ccErr.Title = response.Errors[0].Title
+		ccErr.Detail = response.Errors[0].Detail
+	}
+
+	return ccErr
+}
+
+const defaultTimeout = 30 * time.Second
@@ -0,0 +1,270 @@
+package cc_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/odlp/antifreeze/internal/cc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeConnection struct {
+	endpoint string
+	tokens   []string
+	calls    int
+}
+
+func (c *fakeConnection) ApiEndpoint() (string, error) {
+	return c.endpoint, nil
+}
+
+func (c *fakeConnection) AccessToken() (string, error) {
+	token := c.tokens[c.calls]
+	if c.calls < len(c.tokens)-1 {
+		c.calls++
+	}
+	return token, nil
+}
+
+var _ = Describe("Client", func() {
+	var server *httptest.Server
+	var mux *http.ServeMux
+	var connection *fakeConnection
+	var client *Client
+
+	BeforeEach(func() {
+		mux = http.NewServeMux()
+		server = httptest.NewServer(mux)
+		connection = &fakeConnection{endpoint: server.URL, tokens: []string{"bearer token-1"}}
+		client = NewClient(connection)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("fetches a typed app with the access token", func() {
+		mux.HandleFunc("/v3/apps/app-guid", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Authorization")).To(Equal("bearer token-1"))
+			fmt.Fprint(w, `{
+				"guid": "app-guid",
+				"name": "app-name",
+				"lifecycle": {"type": "docker", "data": {}},
+				"metadata": {"labels": {"team": "payments"}, "annotations": {}},
+				"relationships": {"space": {"data": {"guid": "space-guid"}}}
+			}`)
+		})
+
+		app, err := client.GetApp("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(app.Name).To(Equal("app-name"))
+		Expect(app.Lifecycle.Type).To(Equal("docker"))
+		Expect(*app.Metadata.Labels["team"]).To(Equal("payments"))
+		Expect(app.SpaceGUID()).To(Equal("space-guid"))
+	})
+
+	It("follows pagination", func() {
+		mux.HandleFunc("/v3/apps/app-guid/processes", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `{"pagination": {"next": null}, "resources": [{"type": "worker", "instances": 1}]}`)
+				return
+			}
+
+			Expect(r.URL.Query().Get("per_page")).To(Equal("100"))
+			fmt.Fprintf(w, `{
+				"pagination": {"next": {"href": "%s/v3/apps/app-guid/processes?page=2"}},
+				"resources": [{"type": "web", "instances": 2, "memory_in_mb": 256, "health_check": {"type": "http", "data": {"endpoint": "/health"}}}]
+			}`, server.URL)
+		})
+
+		processes, err := client.GetProcesses("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(processes).To(HaveLen(2))
+		Expect(processes[0].Type).To(Equal("web"))
+		Expect(processes[0].MemoryInMB).To(Equal(int64(256)))
+		Expect(*processes[0].HealthCheck.Data.Endpoint).To(Equal("/health"))
+		Expect(processes[1].Type).To(Equal("worker"))
+	})
+
+	It("returns an empty list when there are no resources", func() {
+		mux.HandleFunc("/v3/apps/app-guid/sidecars", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"pagination": {}, "resources": []}`)
+		})
+
+		sidecars, err := client.GetSidecars("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sidecars).ToNot(BeNil())
+		Expect(sidecars).To(BeEmpty())
+	})
+
+	It("refreshes the token when it's rejected", func() {
+		connection.tokens = []string{"bearer expired", "bearer fresh"}
+
+		mux.HandleFunc("/v3/environment_variable_groups/running", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "bearer fresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"errors": [{"title": "CF-InvalidAuthToken", "detail": "Invalid Auth Token"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"var": {"HTTP_PROXY": "http://proxy"}}`)
+		})
+
+		group, err := client.GetEnvironmentVariableGroup("running")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(group).To(Equal(map[string]interface{}{"HTTP_PROXY": "http://proxy"}))
+	})
+
+	It("adds the bearer prefix to bare tokens", func() {
+		connection.tokens = []string{"token-1"}
+
+		mux.HandleFunc("/v3/apps/app-guid/features", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Authorization")).To(Equal("bearer token-1"))
+			fmt.Fprint(w, `{"resources": [{"name": "ssh", "enabled": true}], "pagination": {}}`)
+		})
+
+		features, err := client.GetAppFeatures("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(features).To(Equal([]AppFeature{{Name: "ssh", Enabled: true}}))
+	})
+
+	It("fetches the package of the current droplet", func() {
+		mux.HandleFunc("/v3/apps/app-guid/droplets/current", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"guid": "droplet-guid", "image": "nginx:1.25", "links": {"package": {"href": "%s/v3/packages/package-guid"}}}`, server.URL)
+		})
+		mux.HandleFunc("/v3/packages/package-guid", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"guid": "package-guid", "type": "docker", "data": {"image": "nginx:1.25", "username": "deployer"}}`)
+		})
+
+		droplet, err := client.GetCurrentDroplet("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*droplet.Image).To(Equal("nginx:1.25"))
+		Expect(droplet.PackageGUID()).To(Equal("package-guid"))
+
+		pkg, err := client.GetPackage(droplet.PackageGUID())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pkg.Data.Username).To(Equal("deployer"))
+	})
+
+	It("returns Cloud Controller errors", func() {
+		mux.HandleFunc("/v3/apps/missing-guid/env", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": [{"code": 10010, "title": "CF-ResourceNotFound", "detail": "App not found"}]}`)
+		})
+
+		_, err := client.GetAppEnvironment("missing-guid")
+		Expect(err).To(MatchError("App not found"))
+		Expect(IsNotFound(err)).To(BeTrue())
+	})
+
+	It("follows v2 pagination paths", func() {
+		mux.HandleFunc("/v2/apps", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("q")).To(Equal("space_guid:space-guid"))
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `{"next_url": null, "resources": [{"metadata": {"guid": "app-2"}, "entity": {"name": "two"}}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"next_url": "/v2/apps?q=space_guid:space-guid&page=2", "resources": [{"metadata": {"guid": "app-1"}, "entity": {"name": "one", "environment_json": {"KEY": "value"}}}]}`)
+		})
+
+		apps, err := client.GetV2Apps("space-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(apps).To(HaveLen(2))
+		Expect(apps[0].Entity.EnvironmentVariables).To(Equal(map[string]interface{}{"KEY": "value"}))
+		Expect(apps[1].Metadata.GUID).To(Equal("app-2"))
+	})
+
+	It("names service bindings after their included service instances", func() {
+		mux.HandleFunc("/v3/service_credential_bindings", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("app_guids")).To(Equal("app-1,app-2"))
+			Expect(r.URL.Query().Get("include")).To(Equal("service_instance"))
+			fmt.Fprint(w, `{
+				"pagination": {},
+				"resources": [{"relationships": {"app": {"data": {"guid": "app-1"}}, "service_instance": {"data": {"guid": "si-1"}}}}],
+				"included": {"service_instances": [{"guid": "si-1", "name": "postgres-db"}]}
+			}`)
+		})
+
+		bindings, err := client.GetServiceBindings([]string{"app-1", "app-2"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bindings).To(HaveLen(1))
+		Expect(bindings[0].AppGUID()).To(Equal("app-1"))
+		Expect(bindings[0].ServiceInstanceName).To(Equal("postgres-db"))
+	})
+
+	It("retries transient errors with backoff", func() {
+		requests := 0
+		mux.HandleFunc("/v3/apps/app-guid", func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"guid": "app-guid"}`)
+		})
+
+		client.SetRetries(2, time.Millisecond)
+
+		app, err := client.GetApp("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(app.GUID).To(Equal("app-guid"))
+		Expect(requests).To(Equal(3))
+	})
+
+	It("gives up once retries run out", func() {
+		requests := 0
+		mux.HandleFunc("/v3/apps/app-guid", func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusBadGateway)
+		})
+
+		client.SetRetries(1, time.Millisecond)
+
+		_, err := client.GetApp("app-guid")
+		Expect(err).To(MatchError("Cloud Controller responded with status 502"))
+		Expect(requests).To(Equal(2))
+	})
+
+	It("doesn't retry errors which won't go away", func() {
+		requests := 0
+		mux.HandleFunc("/v3/apps/app-guid", func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusNotFound)
+		})
+
+		client.SetRetries(2, time.Millisecond)
+
+		_, err := client.GetApp("app-guid")
+		Expect(IsNotFound(err)).To(BeTrue())
+		Expect(requests).To(Equal(1))
+	})
+
+	It("times out slow requests", func() {
+		mux.HandleFunc("/v3/apps/app-guid", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+		})
+
+		client.SetTimeout(10 * time.Millisecond)
+
+		_, err := client.GetApp("app-guid")
+		Expect(err).To(MatchError(ContainSubstring("Unable to reach the Cloud Controller")))
+	})
+
+	It("lists audit events newest first", func() {
+		mux.HandleFunc("/v3/audit_events", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("order_by")).To(Equal("-created_at"))
+			Expect(r.URL.Query().Get("target_guids")).To(Equal("app-guid"))
+			fmt.Fprint(w, `{"pagination": {}, "resources": [
+				{"type": "audit.app.update", "created_at": "2017-07-20T10:00:00Z", "actor": {"name": "jane"}, "data": {"request": {}}}
+			]}`)
+		})
+
+		events, err := client.GetAuditEvents(map[string][]string{"target_guids": {"app-guid"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Actor.Name).To(Equal("jane"))
+		Expect(string(events[0].Data)).To(Equal(`{"request":{}}`))
+	})
+})
@@ -0,0 +1,337 @@
+package cc
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+type Metadata struct {
+	Labels      map[string]*string `json:"labels"`
+	Annotations map[string]*string `json:"annotations"`
+}
+
+type App struct {
+	GUID      string    `json:"guid"`
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Lifecycle Lifecycle `json:"lifecycle"`
+	Metadata  Metadata  `json:"metadata"`
+
+	Relationships struct {
+		Space struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"space"`
+	} `json:"relationships"`
+}
+
+func (a App) SpaceGUID() string {
+	return a.Relationships.Space.Data.GUID
+}
+
+type Lifecycle struct {
+	Type string `json:"type"`
+	Data struct {
+		Buildpacks []string `json:"buildpacks"`
+		Stack      string   `json:"stack"`
+	} `json:"data"`
+}
+
+type Process struct {
+	GUID        string      `json:"guid"`
+	Type        string      `json:"type"`
+	Command     *string     `json:"command"`
+	Instances   int         `json:"instances"`
+	MemoryInMB  int64       `json:"memory_in_mb"`
+	DiskInMB    int64       `json:"disk_in_mb"`
+	HealthCheck HealthCheck `json:"health_check"`
+
+	Relationships struct {
+		App relationship `json:"app"`
+	} `json:"relationships"`
+}
+
+func (p Process) AppGUID() string {
+	return p.Relationships.App.Data.GUID
+}
+
+type relationship struct {
+	Data struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+type HealthCheck struct {
+	Type string `json:"type"`
+	Data struct {
+		Timeout           *int    `json:"timeout"`
+		InvocationTimeout *int    `json:"invocation_timeout"`
+		Endpoint          *string `json:"endpoint"`
+	} `json:"data"`
+}
+
+type Sidecar struct {
+	GUID         string   `json:"guid"`
+	Name         string   `json:"name"`
+	Command      string   `json:"command"`
+	ProcessTypes []string `json:"process_types"`
+	MemoryInMB   *int64   `json:"memory_in_mb"`
+	Origin       string   `json:"origin"`
+}
+
+type Droplet struct {
+	GUID  string  `json:"guid"`
+	State string  `json:"state"`
+	Image *string `json:"image"`
+	Links struct {
+		Package *link `json:"package"`
+	} `json:"links"`
+}
+
+// PackageGUID is the GUID of the package the droplet was staged from.
+func (d Droplet) PackageGUID() string {
+	if d.Links.Package == nil {
+		return ""
+	}
+	return path.Base(d.Links.Package.Href)
+}
+
+type Package struct {
+	GUID string `json:"guid"`
+	Type string `json:"type"`
+	Data struct {
+		Image    string `json:"image"`
+		Username string `json:"username"`
+	} `json:"data"`
+}
+
+type AppFeature struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// AppEnvironment is an app's environment, split by where each variable
+// comes from.
+type AppEnvironment struct {
+	EnvironmentVariables map[string]interface{} `json:"environment_variables"`
+	StagingEnvJSON       map[string]interface{} `json:"staging_env_json"`
+	RunningEnvJSON       map[string]interface{} `json:"running_env_json"`
+	SystemEnvJSON        map[string]interface{} `json:"system_env_json"`
+	ApplicationEnvJSON   map[string]interface{} `json:"application_env_json"`
+}
+
+// ServiceBinding is an app's binding to a service instance.
+type ServiceBinding struct {
+	GUID string `json:"guid"`
+	Type string `json:"type"`
+
+	Relationships struct {
+		App             relationship `json:"app"`
+		ServiceInstance relationship `json:"service_instance"`
+	} `json:"relationships"`
+
+	// ServiceInstanceName is filled in from the included service instance.
+	ServiceInstanceName string `json:"-"`
+}
+
+func (b ServiceBinding) AppGUID() string {
+	return b.Relationships.App.Data.GUID
+}
+
+func (b ServiceBinding) ServiceInstanceGUID() string {
+	return b.Relationships.ServiceInstance.Data.GUID
+}
+
+// V2App is an app as listed by the v2 API, which unlike the v3 API can list
+// the ENV vars of every app in a space at once.
+type V2App struct {
+	Metadata struct {
+		GUID string `json:"guid"`
+	} `json:"metadata"`
+	Entity struct {
+		Name                 string                 `json:"name"`
+		State                string                 `json:"state"`
+		Instances            int                    `json:"instances"`
+		Memory               int64                  `json:"memory"`
+		DiskQuota            int64                  `json:"disk_quota"`
+		EnvironmentVariables map[string]interface{} `json:"environment_json"`
+	} `json:"entity"`
+}
+
+type AuditEvent struct {
+	GUID      string    `json:"guid"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Actor     struct {
+		GUID string `json:"guid"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"actor"`
+	Target struct {
+		GUID string `json:"guid"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"target"`
+	Data json.RawMessage `json:"data"`
+}
+
+func (c *Client) GetApp(guid string) (App, error) {
+	var app App
+	err := c.get("/v3/apps/"+guid, nil, &app)
+	return app, err
+}
+
+// GetApps lists the apps in a space, optionally limited to the given names.
+func (c *Client) GetApps(spaceGUID string, names ...string) ([]App, error) {
+	query := url.Values{}
+	query.Set("space_guids", spaceGUID)
+	query.Set("per_page", perPage)
+	if len(names) > 0 {
+		query.Set("names", strings.Join(names, ","))
+	}
+
+	var apps []App
+	err := c.list("/v3/apps", query, &apps)
+	return apps, err
+}
+
+// GetSpaceProcesses lists the processes of every app in a space.
+func (c *Client) GetSpaceProcesses(spaceGUID string) ([]Process, error) {
+	query := url.Values{}
+	query.Set("space_guids", spaceGUID)
+	query.Set("per_page", bulkPerPage)
+
+	var processes []Process
+	err := c.list("/v3/processes", query, &processes)
+	return processes, err
+}
+
+// GetV2Apps lists the apps in a space with their ENV vars.
+func (c *Client) GetV2Apps(spaceGUID string) ([]V2App, error) {
+	query := url.Values{}
+	query.Set("q", "space_guid:"+spaceGUID)
+	query.Set("results-per-page", v2PerPage)
+
+	var apps []V2App
+	err := c.listV2("/v2/apps", query, &apps)
+	return apps, err
+}
+
+// GetServiceBindings lists the service bindings of the given apps, with the
+// names of their service instances. Apps are looked up in batches, to keep
+// URLs short.
+func (c *Client) GetServiceBindings(appGUIDs []string) ([]ServiceBinding, error) {
+	var bindings []ServiceBinding
+
+	for start := 0; start < len(appGUIDs); start += guidsPerRequest {
+		end := start + guidsPerRequest
+		if end > len(appGUIDs) {
+			end = len(appGUIDs)
+		}
+
+		query := url.Values{}
+		query.Set("type", "app")
+		query.Set("app_guids", strings.Join(appGUIDs[start:end], ","))
+		query.Set("include", "service_instance")
+		query.Set("per_page", bulkPerPage)
+
+		var batch []ServiceBinding
+		var instances []struct {
+			GUID string `json:"guid"`
+			Name string `json:"name"`
+		}
+
+		err := c.listIncluding("/v3/service_credential_bindings", query, &batch, "service_instances", &instances)
+		if err != nil {
+			return nil, err
+		}
+
+		names := map[string]string{}
+		for _, instance := range instances {
+			names[instance.GUID] = instance.Name
+		}
+
+		for i := range batch {
+			batch[i].ServiceInstanceName = names[batch[i].ServiceInstanceGUID()]
+		}
+
+		bindings = append(bindings, batch...)
+	}
+
+	return bindings, nil
+}
+
+func (c *Client) GetProcesses(appGUID string) ([]Process, error) {
+	var processes []Process
+	err := c.list("/v3/apps/"+appGUID+"/processes", pageQuery(), &processes)
+	return processes, err
+}
+
+func (c *Client) GetSidecars(appGUID string) ([]Sidecar, error) {
+	var sidecars []Sidecar
+	err := c.list("/v3/apps/"+appGUID+"/sidecars", pageQuery(), &sidecars)
+	return sidecars, err
+}
+
+func (c *Client) GetCurrentDroplet(appGUID string) (Droplet, error) {
+	var droplet Droplet
+	err := c.get("/v3/apps/"+appGUID+"/droplets/current", nil, &droplet)
+	return droplet, err
+}
+
+func (c *Client) GetPackage(guid string) (Package, error) {
+	var pkg Package
+	err := c.get("/v3/packages/"+guid, nil, &pkg)
+	return pkg, err
+}
+
+func (c *Client) GetAppFeatures(appGUID string) ([]AppFeature, error) {
+	var features []AppFeature
+	err := c.list("/v3/apps/"+appGUID+"/features", nil, &features)
+	return features, err
+}
+
+func (c *Client) GetAppEnvironment(appGUID string) (AppEnvironment, error) {
+	var env AppEnvironment
+	err := c.get("/v3/apps/"+appGUID+"/env", nil, &env)
+	return env, err
+}
+
+// GetEnvironmentVariableGroup fetches the `running` or `staging` group.
+func (c *Client) GetEnvironmentVariableGroup(name string) (map[string]interface{}, error) {
+	var group struct {
+		Var map[string]interface{} `json:"var"`
+	}
+	err := c.get("/v3/environment_variable_groups/"+name, nil, &group)
+	return group.Var, err
+}
+
+// GetAuditEvents lists audit events, filtered by query parameters such as
+// `types`, `target_guids` or `space_guids`, newest first.
+func (c *Client) GetAuditEvents(query url.Values) ([]AuditEvent, error) {
+	q := pageQuery()
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("order_by", "-created_at")
+
+	var events []AuditEvent
+	err := c.list("/v3/audit_events", q, &events)
+	return events, err
+}
+
+func pageQuery() url.Values {
+	return url.Values{"per_page": {perPage}}
+}
+
+const (
+	perPage     = "100"
+	bulkPerPage = "5000"
+	v2PerPage   = "100"
+
+	guidsPerRequest = 50
+)
@@ -0,0 +1,146 @@
+// Package fakecc is a fake Cloud Controller for tests.
+package fakecc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// App is an app in a Space.
+type App struct {
+	Name     string
+	Env      map[string]interface{}
+	Services []string
+	Labels   map[string]string
+}
+
+// Space is a Cloud Controller serving one space's apps, counting the
+// requests made to it and the most it served at once.
+type Space struct {
+	Apps  []App
+	Delay time.Duration
+
+	// Failing are path suffixes, such as "/sidecars", answered with a
+	// server error.
+	Failing []string
+
+	Requests, MaxAtOnce int
+
+	// Paths are the paths requested, in order.
+	Paths []string
+
+	mu       sync.Mutex
+	inFlight int
+}
+
+func (s *Space) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.Requests++
+	s.Paths = append(s.Paths, r.URL.Path)
+	s.inFlight++
+	if s.inFlight > s.MaxAtOnce {
+		s.MaxAtOnce = s.inFlight
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+
+	time.Sleep(s.Delay)
+
+	for _, suffix := range s.Failing {
+		if strings.HasSuffix(r.URL.Path, suffix) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var resources []interface{}
+	response := map[string]interface{}{"pagination": map[string]interface{}{}}
+
+	switch path := r.URL.Path; {
+	case path == "/v3/apps":
+		for _, app := range s.Apps {
+			labels := map[string]interface{}{}
+			for k, v := range app.Labels {
+				labels[k] = v
+			}
+			resources = append(resources, map[string]interface{}{
+				"guid":      app.Name + "-guid",
+				"name":      app.Name,
+				"lifecycle": map[string]interface{}{"type": "buildpack"},
+				"metadata":  map[string]interface{}{"labels": labels, "annotations": map[string]interface{}{}},
+			})
+		}
+	case path == "/v2/apps":
+		for _, app := range s.Apps {
+			resources = append(resources, map[string]interface{}{
+				"metadata": map[string]interface{}{"guid": app.Name + "-guid"},
+				"entity":   map[string]interface{}{"name": app.Name, "instances": 1, "memory": 256, "environment_json": app.Env},
+			})
+		}
+		response = map[string]interface{}{"next_url": nil}
+	case path == "/v3/processes":
+		for _, app := range s.Apps {
+			resources = append(resources, map[string]interface{}{
+				"type":          "web",
+				"instances":     1,
+				"memory_in_mb":  256,
+				"relationships": map[string]interface{}{"app": map[string]interface{}{"data": map[string]interface{}{"guid": app.Name + "-guid"}}},
+			})
+		}
+	case path == "/v3/service_credential_bindings":
+		var instances []interface{}
+		for _, app := range s.Apps {
+			if !strings.Contains(r.URL.Query().Get("app_guids"), app.Name+"-guid") {
+				continue
+			}
+			for _, service := range app.Services {
+				resources = append(resources, map[string]interface{}{
+					"relationships": map[string]interface{}{
+						"app":              map[string]interface{}{"data": map[string]interface{}{"guid": app.Name + "-guid"}},
+						"service_instance": map[string]interface{}{"data": map[string]interface{}{"guid": service + "-guid"}},
+					},
+				})
+				instances = append(instances, map[string]interface{}{"guid": service + "-guid", "name": service})
+			}
+		}
+		response["included"] = map[string]interface{}{"service_instances": instances}
+	case strings.HasPrefix(path, "/v3/environment_variable_groups/"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"var": map[string]interface{}{}})
+		return
+	case strings.HasSuffix(path, "/env"):
+		fmt.Fprint(w, `{"environment_variables": {}, "system_env_json": {}}`)
+		return
+	case strings.HasSuffix(path, "/sidecars"), path == "/v3/audit_events":
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	response["resources"] = resources
+	json.NewEncoder(w).Encode(response)
+}
+
+// NewSpace serves n apps, each with an ENV var and a service.
+func NewSpace(n int) *Space {
+	space := &Space{}
+	for i := 0; i < n; i++ {
+		space.Apps = append(space.Apps, App{
+			Name:     fmt.Sprintf("app-%d", i),
+			Env:      map[string]interface{}{"ENV_VAR": "value"},
+			Services: []string{fmt.Sprintf("db-%d", i)},
+		})
+	}
+	return space
+}
@@ -0,0 +1,133 @@
+// Package secrets recognises credentials in ENV values, such as private
+// keys, access keys and tokens, and ENV vars named like they hold one.
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// secretDetector recognises one kind of credential in an ENV value and
+// returns the fragment which gave it away.
+type secretDetector struct {
+	kind   string
+	detect func(value string) string
+}
+
+var secretDetectors = []secretDetector{
+	{kind: "a private key", detect: patternDetector(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{kind: "an AWS access key", detect: patternDetector(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{kind: "a JWT", detect: patternDetector(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{kind: "a URL with a password", detect: urlPasswordDetector},
+	{kind: "a random token", detect: entropyDetector},
+}
+
+var urlPasswordPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]*:([^/\s@]+)@`)
+var tokenPattern = regexp.MustCompile(`[A-Za-z0-9+/=_]+`)
+
+const (
+	minTokenLength  = 20
+	minTokenEntropy = 4.0
+)
+
+// Detect returns the kind of the first credential found in value, and the
+// fragment of the value which gave it away.
+func Detect(value string) (kind, fragment string, ok bool) {
+	for _, detector := range secretDetectors {
+		if fragment := detector.detect(value); fragment != "" {
+			return detector.kind, fragment, true
+		}
+	}
+	return "", "", false
+}
+
+func patternDetector(pattern string) func(string) string {
+	re := regexp.MustCompile(pattern)
+	return func(value string) string {
+		return re.FindString(value)
+	}
+}
+
+func urlPasswordDetector(value string) string {
+	if match := urlPasswordPattern.FindStringSubmatch(value); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// entropyDetector finds long tokens whose characters are spread evenly
+// enough to have been generated, such as API keys.
+func entropyDetector(value string) string {
+	for _, token := range tokenPattern.FindAllString(value, -1) {
+		if len(token) >= minTokenLength && shannonEntropy(token) >= minTokenEntropy {
+			return token
+		}
+	}
+	return ""
+}
+
+// shannonEntropy is the average number of bits of information per
+// character of s.
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+
+	var entropy float64
+	length := float64(len([]rune(s)))
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+var nameFragments = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "CREDENTIAL", "PRIVATE_KEY", "API_KEY", "APIKEY", "ACCESS_KEY"}
+
+// IsSecretName reports whether a key is named like it holds a credential,
+// such as `DATABASE_PASSWORD` or `api_key`, whatever its value.
+func IsSecretName(key string) bool {
+	key = strings.ToUpper(key)
+	for _, fragment := range nameFragments {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces a value with a placeholder holding its SHA-256 digest,
+// so it can still be compared with an expected value without being known.
+func Redact(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return redactedPrefix + hex.EncodeToString(sum[:]) + redactedSuffix
+}
+
+// RedactedDigest returns the digest held by a placeholder made by Redact.
+func RedactedDigest(value string) (string, bool) {
+	if !redactedPattern.MatchString(value) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(value, redactedPrefix), redactedSuffix), true
+}
+
+const (
+	redactedPrefix = "[REDACTED sha256:"
+	redactedSuffix = "]"
+)
+
+var redactedPattern = regexp.MustCompile(`^\[REDACTED sha256:[0-9a-f]{64}\]$`)
+
+// Mask keeps just enough of a secret to recognise it: the first four
+// and last two characters of long values, and nothing of short ones.
+func Mask(secret string) string {
+	runes := []rune(secret)
+	if len(runes) < 12 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:4]) + strings.Repeat("*", len(runes)-6) + string(runes[len(runes)-2:])
+}
@@ -0,0 +1,373 @@
+// Package manifest reads Cloud Foundry application manifests, recording
+// the lines each attribute is declared on so findings can point at them.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+type YManifest struct {
+	Applications []YApplication `yaml:"applications"`
+
+	// Node is the document as parsed by a position-aware decoder, used to
+	// point findings at manifest lines.
+	Node *yaml3.Node `yaml:"-"`
+
+	// Files are the manifest files merged into the document, in order.
+	Files []YFile `yaml:"-"`
+}
+
+type YApplication struct {
+	Name      string                 `yaml:"name"`
+	Env       map[string]interface{} `yaml:"env"`
+	Services  []string               `yaml:"services"`
+	Processes []YProcess             `yaml:"processes"`
+	Sidecars  []YSidecar             `yaml:"sidecars"`
+	Metadata  YMetadata              `yaml:"metadata"`
+	Docker    *YDocker               `yaml:"docker"`
+
+	// Instances, Memory, DiskQuota and HealthCheckType describe the web
+	// process, unless `processes:` declares it.
+	Instances       *int   `yaml:"instances"`
+	Memory          string `yaml:"memory"`
+	DiskQuota       string `yaml:"disk_quota"`
+	HealthCheckType string `yaml:"health-check-type"`
+	RandomRoute     bool   `yaml:"random-route"`
+
+	Lines YLines `yaml:"-"`
+}
+
+type YProcess struct {
+	Type                    string `yaml:"type"`
+	Command                 string `yaml:"command"`
+	Instances               *int   `yaml:"instances"`
+	Memory                  string `yaml:"memory"`
+	DiskQuota               string `yaml:"disk_quota"`
+	HealthCheckType         string `yaml:"health-check-type"`
+	HealthCheckHTTPEndpoint string `yaml:"health-check-http-endpoint"`
+}
+
+type YSidecar struct {
+	Name         string   `yaml:"name"`
+	Command      string   `yaml:"command"`
+	ProcessTypes []string `yaml:"process_types"`
+	Memory       string   `yaml:"memory"`
+}
+
+type YMetadata struct {
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type YDocker struct {
+	Image    string `yaml:"image"`
+	Username string `yaml:"username"`
+}
+
+// YLines records the manifest lines an application's attributes are
+// declared on. Zero means the attribute isn't in the manifest.
+type YLines struct {
+	Name         int
+	Keys         map[string]int
+	Env          int
+	EnvKeys      map[string]int
+	Services     int
+	ServiceNames map[string]int
+	Processes    map[string]YItemLines
+	Sidecars     map[string]YItemLines
+	Labels       YItemLines
+	Annotations  YItemLines
+	Docker       YItemLines
+}
+
+// YItemLines records where an entry in a list, such as a process, and each
+// of its keys are declared.
+type YItemLines struct {
+	Line int
+	Keys map[string]int
+}
+
+func ParseManifest(manifestPath, appName string) (manifestEnv []string, manifestServices []string, err error) {
+	app, err := LoadApplication(manifestPath, appName)
+
+	if err != nil {
+		return manifestEnv, manifestServices, err
+	}
+
+	for k := range app.Env {
+		manifestEnv = append(manifestEnv, k)
+	}
+
+	return manifestEnv, app.Services, nil
+}
+
+// LoadApplication reads a manifest, with any ops files applied, and
+// returns the named application.
+func LoadApplication(manifestPath, appName string, opsPaths ...string) (YApplication, error) {
+	document, err := Load(manifestPath, opsPaths...)
+
+	if err != nil {
+		return YApplication{}, err
+	}
+
+	return document.Application(appName)
+}
+
+// Load reads a manifest, applies any ops files to it in order, and locates
+// each application's attributes in it.
+func Load(manifestPath string, opsPaths ...string) (manifest YManifest, err error) {
+	return LoadMerged([]string{manifestPath}, opsPaths)
+}
+
+// LoadMerged reads one or more manifest files, deep merged in order, then
+// applies any ops files in order. Lines count on through each file in turn,
+// as if they were concatenated; Locate maps them back to a file.
+func LoadMerged(manifestPaths, opsPaths []string) (manifest YManifest, err error) {
+	return LoadMergedWith(Read, manifestPaths, opsPaths)
+}
+
+// ReadFunc reads a manifest or ops file, such as from a git revision
+// rather than the working tree.
+type ReadFunc func(path string) ([]byte, error)
+
+// LoadMergedWith loads manifests like LoadMerged, reading every file with
+// read.
+func LoadMergedWith(read ReadFunc, manifestPaths, opsPaths []string) (manifest YManifest, err error) {
+	root, files, err := readMerged(read, manifestPaths)
+
+	if err != nil {
+		return YManifest{}, err
+	}
+
+	if len(opsPaths) > 0 {
+		if err := applyOpsFiles(read, root, opsPaths); err != nil {
+			return YManifest{}, err
+		}
+	}
+
+	// A manifest read as it is is decoded from its own bytes, so nothing is
+	// lost re-encoding it.
+	var b []byte
+	if len(manifestPaths) == 1 && len(opsPaths) == 0 {
+		b, err = read(manifestPaths[0])
+	} else if b, err = yaml3.Marshal(root); err != nil {
+		err = fmt.Errorf("Unable to merge manifest files: %s", err)
+	}
+
+	if err != nil {
+		return YManifest{}, err
+	}
+
+	// Values are decoded by yaml.v2, as they are by the cf CLI, so the
+	// decoded types match what gets pushed.
+	var document YManifest
+	err = yaml.Unmarshal(b, &document)
+
+	if err != nil {
+		return YManifest{}, fmt.Errorf("Unable to parse manifest YAML")
+	}
+
+	document.Node = root
+	document.Files = files
+	locateApplications(&document)
+
+	return document, nil
+}
+
+// Application returns the named application.
+func (m YManifest) Application(appName string) (YApplication, error) {
+	return findApp(appName, m.Applications)
+}
+
+func locateApplications(document *YManifest) {
+	if len(document.Node.Content) == 0 {
+		return
+	}
+
+	applications := MappingValue(ResolveAlias(document.Node.Content[0]), "applications")
+	if applications == nil || applications.Kind != yaml3.SequenceNode {
+		return
+	}
+
+	for i, node := range applications.Content {
+		if i >= len(document.Applications) {
+			break
+		}
+
+		node = ResolveAlias(node)
+		lines := YLines{
+			Name:         node.Line,
+			Keys:         KeyLines(node),
+			EnvKeys:      map[string]int{},
+			ServiceNames: map[string]int{},
+		}
+
+		if key, value := MappingEntry(node, "name"); key != nil {
+			lines.Name = value.Line
+		}
+
+		if key, env := MappingEntry(node, "env"); key != nil {
+			lines.Env = key.Line
+			for j := 0; j+1 < len(env.Content); j += 2 {
+				lines.EnvKeys[env.Content[j].Value] = env.Content[j].Line
+			}
+		}
+
+		if key, services := MappingEntry(node, "services"); key != nil {
+			lines.Services = key.Line
+			for _, service := range services.Content {
+				lines.ServiceNames[service.Value] = service.Line
+			}
+		}
+
+		if _, processes := MappingEntry(node, "processes"); processes != nil {
+			lines.Processes = itemLines(processes, "type")
+		}
+
+		if _, sidecars := MappingEntry(node, "sidecars"); sidecars != nil {
+			lines.Sidecars = itemLines(sidecars, "name")
+		}
+
+		if key, docker := MappingEntry(node, "docker"); key != nil {
+			lines.Docker = YItemLines{Line: key.Line, Keys: KeyLines(docker)}
+		}
+
+		if _, metadata := MappingEntry(node, "metadata"); metadata != nil {
+			if key, labels := MappingEntry(metadata, "labels"); key != nil {
+				lines.Labels = YItemLines{Line: key.Line, Keys: KeyLines(labels)}
+			}
+			if key, annotations := MappingEntry(metadata, "annotations"); key != nil {
+				lines.Annotations = YItemLines{Line: key.Line, Keys: KeyLines(annotations)}
+			}
+		}
+
+		document.Applications[i].Lines = lines
+	}
+}
+
+// KeyLines records the line each key of a mapping is declared on.
+func KeyLines(mapping *yaml3.Node) map[string]int {
+	lines := map[string]int{}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		lines[mapping.Content[i].Value] = mapping.Content[i].Line
+	}
+	return lines
+}
+
+// itemLines locates the entries of a list of mappings by their identifying
+// key, such as a process's type.
+func itemLines(sequence *yaml3.Node, idKey string) map[string]YItemLines {
+	items := map[string]YItemLines{}
+
+	for _, item := range sequence.Content {
+		item = ResolveAlias(item)
+		if id := MappingValue(item, idKey); id != nil {
+			items[id.Value] = YItemLines{Line: item.Line, Keys: KeyLines(item)}
+		}
+	}
+
+	return items
+}
+
+// Read reads a manifest's contents.
+func Read(manifestPath string) ([]byte, error) {
+	b, err := ioutil.ReadFile(manifestPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read manifest file: %s", manifestPath)
+	}
+
+	return b, nil
+}
+
+// LoadCompanionFile decodes a YAML file read alongside the manifest, such
+// as a pins file, and returns its top-level mapping so entries can be
+// located.
+func LoadCompanionFile(path, kind string, v interface{}) (*yaml3.Node, error) {
+	return LoadCompanionFileWith(ioutil.ReadFile, path, kind, v)
+}
+
+// LoadCompanionFileWith loads a companion file like LoadCompanionFile,
+// reading it with read.
+func LoadCompanionFileWith(read ReadFunc, path, kind string, v interface{}) (*yaml3.Node, error) {
+	b, err := read(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %s", kind, path)
+	}
+
+	var root yaml3.Node
+
+	if yaml.Unmarshal(b, v) != nil || yaml3.Unmarshal(b, &root) != nil {
+		return nil, fmt.Errorf("Unable to parse %s YAML", kind)
+	}
+
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	return ResolveAlias(root.Content[0]), nil
+}
+
+// ApplicationNodes lists the entries under `applications:`, in order.
+func ApplicationNodes(mapping *yaml3.Node) (nodes []*yaml3.Node) {
+	if mapping == nil {
+		return nil
+	}
+
+	applications := MappingValue(mapping, "applications")
+	if applications == nil {
+		return nil
+	}
+
+	for _, node := range applications.Content {
+		nodes = append(nodes, ResolveAlias(node))
+	}
+
+	return nodes
+}
+
+func findApp(appName string, apps []YApplication) (app YApplication, err error) {
+	if len(apps) == 0 {
+		return YApplication{}, fmt.Errorf("No application found in manifest")
+	}
+
+	appIndex := notFoundIndex
+
+	for i := range apps {
+		if apps[i].Name == appName {
+			appIndex = i
+			break
+		}
+	}
+
+	if appIndex == notFoundIndex {
+		return YApplication{}, fmt.Errorf("Application '%s' not found in manifest", appName)
+	}
+
+	return apps[appIndex], nil
+}
+
+// EnvValueString formats a decoded ENV value the way it reaches the app:
+// strings as they are, everything else as its JSON representation.
+func EnvValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
+
+const notFoundIndex = -1
@@ -0,0 +1,95 @@
+package manifest_test
+
+import (
+	. "github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parsing Manifest", func() {
+	It("parses the ENV keys", func() {
+		envKeys, _, err := ParseManifest("../fixtures/manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(envKeys).To(HaveLen(2))
+		Expect(envKeys).To(ContainElement("ENV_VAR_1"))
+		Expect(envKeys).To(ContainElement("ENV_VAR_2"))
+	})
+
+	It("parses the service names", func() {
+		_, serviceNames, err := ParseManifest("../fixtures/manifest.yml", "app-name")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serviceNames).To(HaveLen(2))
+		Expect(serviceNames).To(ContainElement("service-1"))
+		Expect(serviceNames).To(ContainElement("service-2"))
+	})
+
+	Context("multi-app manifest", func() {
+		It("returns the values for the correct app", func() {
+			envKeys, serviceNames, err := ParseManifest("../fixtures/multi-manifest.yml", "app-2")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(serviceNames).To(HaveLen(2))
+			Expect(serviceNames).To(ContainElement("service-3"))
+			Expect(serviceNames).To(ContainElement("service-4"))
+
+			Expect(envKeys).To(HaveLen(2))
+			Expect(envKeys).To(ContainElement("ENV_VAR_3"))
+			Expect(envKeys).To(ContainElement("ENV_VAR_4"))
+		})
+	})
+
+	Context("app doesn't exist in the manifest", func() {
+		It("returns an error", func() {
+			_, _, err := ParseManifest("../fixtures/multi-manifest.yml", "app-666")
+			Expect(err).To(MatchError("Application 'app-666' not found in manifest"))
+		})
+	})
+
+	Context("invalid manifest path", func() {
+		It("returns an error", func() {
+			_, _, err := ParseManifest("./pure-fiction", "fictional-app")
+			Expect(err).To(MatchError("Unable to read manifest file: ./pure-fiction"))
+		})
+	})
+
+	Context("invalid manifest", func() {
+		It("returns an error", func() {
+			_, _, err := ParseManifest("../fixtures/invalid-manifest.json", "app-name")
+			Expect(err).To(MatchError("No application found in manifest"))
+		})
+	})
+
+	Context("manifest positions", func() {
+		It("records the lines of the app's blocks and ENV keys", func() {
+			app, err := LoadApplication("../fixtures/multi-manifest.yml", "app-2")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(app.Lines.Name).To(Equal(12))
+			Expect(app.Lines.Services).To(Equal(15))
+			Expect(app.Lines.Env).To(Equal(18))
+			Expect(app.Lines.EnvKeys).To(Equal(map[string]int{"ENV_VAR_3": 19, "ENV_VAR_4": 20}))
+		})
+	})
+
+	Context("companion files", func() {
+		It("reads the file with the given read func, such as at a git revision", func() {
+			var read []string
+			readAtRevision := func(path string) ([]byte, error) {
+				read = append(read, path)
+				return []byte("applications:\n- name: app-name\n"), nil
+			}
+
+			var v struct {
+				Applications []struct {
+					Name string `yaml:"name"`
+				} `yaml:"applications"`
+			}
+			root, err := LoadCompanionFileWith(readAtRevision, "pins.yml", "pins file", &v)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(read).To(Equal([]string{"pins.yml"}))
+			Expect(v.Applications[0].Name).To(Equal("app-name"))
+			Expect(ApplicationNodes(root)[0].Line).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,172 @@
+package manifest
+
+import (
+	"fmt"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// YFile is one of the manifest files a merged manifest was read from.
+type YFile struct {
+	Path string
+
+	// FirstLine is the first line of the file in the merged manifest, as if
+	// the files had been concatenated in order.
+	FirstLine int
+}
+
+// listIdentityKeys identifies the items of lists of mappings, so an item
+// in a later file is merged into the matching item rather than added.
+var listIdentityKeys = map[string]string{
+	"applications": "name",
+	"processes":    "type",
+	"sidecars":     "name",
+	"routes":       "route",
+}
+
+// mergeNodes deep merges src into dst. Mappings are merged key by key, and
+// lists of mappings listed in listIdentityKeys item by item. Other lists,
+// such as services, gain the items they don't already have. Anything else
+// is replaced by src.
+func mergeNodes(dst, src *yaml3.Node) {
+	dst, src = ResolveAlias(dst), ResolveAlias(src)
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], ResolveAlias(src.Content[i+1])
+
+		j := -1
+		for k := 0; k+1 < len(dst.Content); k += 2 {
+			if dst.Content[k].Value == key.Value {
+				j = k
+			}
+		}
+
+		if j == -1 {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+
+		existing := child(dst, j+1)
+
+		switch {
+		case existing.Kind == yaml3.MappingNode && value.Kind == yaml3.MappingNode:
+			mergeNodes(existing, value)
+		case existing.Kind == yaml3.SequenceNode && value.Kind == yaml3.SequenceNode:
+			mergeLists(existing, value, listIdentityKeys[key.Value])
+		default:
+			dst.Content[j], dst.Content[j+1] = key, value
+		}
+	}
+}
+
+func mergeLists(dst, src *yaml3.Node, identityKey string) {
+	for _, item := range src.Content {
+		item = ResolveAlias(item)
+
+		if match := findListItem(dst, item, identityKey); match != -1 {
+			if identityKey != "" && item.Kind == yaml3.MappingNode {
+				mergeNodes(child(dst, match), item)
+			}
+			continue
+		}
+
+		dst.Content = append(dst.Content, item)
+	}
+}
+
+// findListItem finds an item in a list with the same identity as item: the
+// same value of identityKey for mappings, or the same value for scalars.
+func findListItem(list, item *yaml3.Node, identityKey string) int {
+	for i, existing := range list.Content {
+		existing = ResolveAlias(existing)
+
+		if item.Kind == yaml3.ScalarNode && existing.Kind == yaml3.ScalarNode && item.Value == existing.Value {
+			return i
+		}
+
+		if identityKey == "" || item.Kind != yaml3.MappingNode || existing.Kind != yaml3.MappingNode {
+			continue
+		}
+
+		id, existingID := MappingValue(item, identityKey), MappingValue(existing, identityKey)
+		if id != nil && existingID != nil && id.Value == existingID.Value {
+			return i
+		}
+	}
+	return -1
+}
+
+// offsetLines moves every line of a document on by offset.
+func offsetLines(node *yaml3.Node, offset int) {
+	if node.Line != 0 {
+		node.Line += offset
+	}
+	for _, c := range node.Content {
+		offsetLines(c, offset)
+	}
+}
+
+// readMerged reads each manifest file and deep merges them in order.
+func readMerged(read ReadFunc, manifestPaths []string) (*yaml3.Node, []YFile, error) {
+	var root *yaml3.Node
+	var files []YFile
+	firstLine := 1
+
+	for _, manifestPath := range manifestPaths {
+		b, err := read(manifestPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var document yaml3.Node
+		if err := yaml3.Unmarshal(b, &document); err != nil {
+			return nil, nil, fmt.Errorf("Unable to parse manifest YAML: %s", manifestPath)
+		}
+
+		offsetLines(&document, firstLine-1)
+		files = append(files, YFile{Path: manifestPath, FirstLine: firstLine})
+		firstLine += countLines(b)
+
+		switch {
+		case root == nil:
+			root = &document
+		case len(document.Content) == 0:
+		case len(root.Content) == 0:
+			root = &document
+		default:
+			mergeNodes(root.Content[0], document.Content[0])
+		}
+	}
+
+	return root, files, nil
+}
+
+func countLines(b []byte) int {
+	lines := 1
+	for _, c := range b {
+		if c == '\n' {
+			lines++
+		}
+	}
+	return lines
+}
+
+// Locate maps a line of a merged manifest back to the file it was read
+// from and the line within that file.
+func (m YManifest) Locate(line int) (string, int) {
+	if len(m.Files) == 0 {
+		return "", line
+	}
+
+	file := m.Files[0]
+	for _, f := range m.Files {
+		if line >= f.FirstLine {
+			file = f
+		}
+	}
+
+	if line == 0 {
+		return file.Path, 0
+	}
+	return file.Path, line - file.FirstLine + 1
+}
@@ -0,0 +1,62 @@
+package manifest_test
+
+import (
+	. "github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Merging Manifests", func() {
+	var document YManifest
+
+	BeforeEach(func() {
+		var err error
+		document, err = LoadMerged([]string{"../fixtures/merge/base.yml", "../fixtures/merge/prod.yml"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("merges apps by name and their ENV vars by key, later files winning", func() {
+		Expect(document.Applications).To(HaveLen(2))
+
+		app, err := document.Application("app-name")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*app.Instances).To(Equal(4))
+		Expect(app.Memory).To(Equal("256M"))
+		Expect(app.Env).To(Equal(map[string]interface{}{
+			"ENV_VAR_1": 1800,
+			"ENV_VAR_2": "https://example.com",
+			"ENV_VAR_3": "prod",
+		}))
+	})
+
+	It("adds list items which aren't already there", func() {
+		app, err := document.Application("app-name")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(app.Services).To(Equal([]string{"service-1", "service-2"}))
+
+		routes := MappingValue(ApplicationNodes(document.Node.Content[0])[0], "routes")
+		Expect(routes.Content).To(HaveLen(2))
+	})
+
+	It("locates each key in the file which contributed it", func() {
+		app, err := document.Application("app-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		file, line := document.Locate(app.Lines.EnvKeys["ENV_VAR_1"])
+		Expect(file).To(Equal("../fixtures/merge/base.yml"))
+		Expect(line).To(Equal(11))
+
+		file, line = document.Locate(app.Lines.EnvKeys["ENV_VAR_2"])
+		Expect(file).To(Equal("../fixtures/merge/prod.yml"))
+		Expect(line).To(Equal(12))
+
+		file, line = document.Locate(app.Lines.ServiceNames["service-2"])
+		Expect(file).To(Equal("../fixtures/merge/prod.yml"))
+		Expect(line).To(Equal(10))
+	})
+
+	It("names a file it can't read", func() {
+		_, err := LoadMerged([]string{"../fixtures/merge/base.yml", "../fixtures/merge/missing.yml"}, nil)
+		Expect(err).To(MatchError("Unable to read manifest file: ../fixtures/merge/missing.yml"))
+	})
+})
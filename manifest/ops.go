@@ -0,0 +1,361 @@
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Op is a go-patch operation on a manifest, as used in BOSH-style ops
+// files: a replace sets the value at Path, and a remove deletes it.
+type Op struct {
+	Type  string      `yaml:"type"`
+	Path  string      `yaml:"path"`
+	Value interface{} `yaml:"value,omitempty"`
+}
+
+const (
+	OpReplace = "replace"
+	OpRemove  = "remove"
+)
+
+var pathTokenEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+var pathTokenUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// AppPath is the go-patch path of an app's entry in the manifest, followed
+// by tokens within it, such as "env" and "KEY?". Each token is escaped, so
+// a trailing `?` still marks it optional.
+func AppPath(appName string, tokens ...string) string {
+	path := "/applications/name=" + pathTokenEscaper.Replace(appName)
+	for _, token := range tokens {
+		path += "/" + pathTokenEscaper.Replace(token)
+	}
+	return path
+}
+
+// opNode is an op as read from an ops file, keeping its value as a node so
+// it's decoded the same way as the rest of the manifest.
+type opNode struct {
+	Type  string     `yaml:"type"`
+	Path  string     `yaml:"path"`
+	Value yaml3.Node `yaml:"value"`
+}
+
+// ApplyOpsFiles applies the replace and remove operations in each ops file,
+// in order, to a manifest document. Values added by an op take the line of
+// the block they're added to, as they aren't in the manifest itself.
+func ApplyOpsFiles(root *yaml3.Node, opsPaths []string) error {
+	return applyOpsFiles(ioutil.ReadFile, root, opsPaths)
+}
+
+func applyOpsFiles(read ReadFunc, root *yaml3.Node, opsPaths []string) error {
+	for _, opsPath := range opsPaths {
+		b, err := read(opsPath)
+		if err != nil {
+			return fmt.Errorf("Unable to read ops file: %s", opsPath)
+		}
+
+		var ops []opNode
+		if err := yaml3.Unmarshal(b, &ops); err != nil {
+			return fmt.Errorf("Unable to parse ops file YAML: %s", opsPath)
+		}
+
+		if i, err := applyOps(root, ops); err != nil {
+			return fmt.Errorf("Unable to apply op %d (%s %s) in ops file %s: %s", i+1, ops[i].Type, ops[i].Path, opsPath, err)
+		}
+	}
+
+	return nil
+}
+
+// ApplyOps applies replace and remove operations, in order, to a manifest
+// document.
+func ApplyOps(root *yaml3.Node, ops []Op) error {
+	nodes := make([]opNode, len(ops))
+
+	for i, op := range ops {
+		nodes[i] = opNode{Type: op.Type, Path: op.Path}
+		if op.Value == nil {
+			continue
+		}
+		if err := nodes[i].Value.Encode(op.Value); err != nil {
+			return fmt.Errorf("Unable to apply op %d (%s %s): %s", i+1, op.Type, op.Path, err)
+		}
+	}
+
+	if i, err := applyOps(root, nodes); err != nil {
+		return fmt.Errorf("Unable to apply op %d (%s %s): %s", i+1, ops[i].Type, ops[i].Path, err)
+	}
+	return nil
+}
+
+// applyOps returns the index of the op which couldn't be applied, if any.
+func applyOps(root *yaml3.Node, ops []opNode) (int, error) {
+	for i, op := range ops {
+		if err := applyOp(root, op); err != nil {
+			return i, err
+		}
+	}
+	return 0, nil
+}
+
+type pathToken struct {
+	// key is a map key, or the key of a `key=value` match
+	key      string
+	value    string
+	index    int
+	kind     int
+	optional bool
+}
+
+const (
+	keyToken = iota
+	matchToken
+	indexToken
+	appendToken
+)
+
+// parsePath splits a go-patch path into tokens. Once a token is optional,
+// so is every token after it.
+func parsePath(path string) ([]pathToken, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("Expected path to start with '/'")
+	}
+
+	var tokens []pathToken
+	optional := false
+
+	for _, raw := range strings.Split(path[1:], "/") {
+		if strings.HasSuffix(raw, "?") {
+			optional = true
+			raw = strings.TrimSuffix(raw, "?")
+		}
+		raw = pathTokenUnescaper.Replace(raw)
+
+		token := pathToken{key: raw, optional: optional}
+
+		if raw == "-" {
+			token.kind = appendToken
+		} else if i, err := strconv.Atoi(raw); err == nil && i >= 0 {
+			token.kind = indexToken
+			token.index = i
+		} else if parts := strings.SplitN(raw, "=", 2); len(parts) == 2 {
+			token.kind = matchToken
+			token.key, token.value = parts[0], parts[1]
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func applyOp(root *yaml3.Node, op opNode) error {
+	tokens, err := parsePath(op.Path)
+	if err != nil {
+		return err
+	}
+
+	if len(root.Content) == 0 {
+		root.Kind = yaml3.DocumentNode
+		root.Content = []*yaml3.Node{{Kind: yaml3.MappingNode, Tag: "!!map"}}
+	}
+
+	switch op.Type {
+	case OpReplace:
+		if op.Value.Kind == 0 {
+			return fmt.Errorf("Expected a value to replace with")
+		}
+		return replaceAt(root, 0, tokens, &op.Value)
+	case OpRemove:
+		return removeAt(root, 0, tokens)
+	default:
+		return fmt.Errorf("Unknown op type '%s', expected replace or remove", op.Type)
+	}
+}
+
+// child returns the node at index i of parent, copying it first if it's an
+// alias so patching it doesn't change the anchored node too.
+func child(parent *yaml3.Node, i int) *yaml3.Node {
+	if parent.Content[i].Kind == yaml3.AliasNode {
+		parent.Content[i] = copyNode(ResolveAlias(parent.Content[i]), 0)
+		parent.Content[i].Anchor = ""
+	}
+	return parent.Content[i]
+}
+
+// copyNode deep copies a node. A non-zero line replaces the copy's lines.
+func copyNode(node *yaml3.Node, line int) *yaml3.Node {
+	node = ResolveAlias(node)
+	copied := *node
+	copied.Content = nil
+
+	if line != 0 {
+		copied.Line, copied.Column = line, 0
+	}
+
+	for _, c := range node.Content {
+		copied.Content = append(copied.Content, copyNode(c, line))
+	}
+	return &copied
+}
+
+// find locates the content index a token refers to within node, or -1.
+func find(node *yaml3.Node, token pathToken) (int, error) {
+	switch token.kind {
+	case indexToken, appendToken:
+		if node.Kind != yaml3.SequenceNode {
+			return -1, fmt.Errorf("Expected to find an array for '%s'", token.key)
+		}
+		if token.kind == appendToken || token.index >= len(node.Content) {
+			return -1, nil
+		}
+		return token.index, nil
+
+	case matchToken:
+		if node.Kind != yaml3.SequenceNode {
+			return -1, fmt.Errorf("Expected to find an array for '%s=%s'", token.key, token.value)
+		}
+		for i, item := range node.Content {
+			if value := MappingValue(ResolveAlias(item), token.key); value != nil && value.Value == token.value {
+				return i, nil
+			}
+		}
+		return -1, nil
+
+	default:
+		if node.Kind != yaml3.MappingNode {
+			return -1, fmt.Errorf("Expected to find a map for '%s'", token.key)
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == token.key {
+				return i + 1, nil
+			}
+		}
+		return -1, nil
+	}
+}
+
+func notFound(token pathToken) error {
+	switch token.kind {
+	case matchToken:
+		return fmt.Errorf("Expected to find an array item with '%s=%s'", token.key, token.value)
+	case indexToken:
+		return fmt.Errorf("Expected to find array index %d", token.index)
+	default:
+		return fmt.Errorf("Expected to find a map key '%s'", token.key)
+	}
+}
+
+// replaceAt walks tokens from node, the i'th of its parent's content,
+// creating missing optional parts of the path, and sets the last to value.
+func replaceAt(parent *yaml3.Node, i int, tokens []pathToken, value *yaml3.Node) error {
+	node := child(parent, i)
+	token := tokens[0]
+
+	// Added nodes point at the block's key, such as `env:`, when it has one.
+	line := node.Line
+	if parent.Kind == yaml3.MappingNode && i > 0 {
+		line = parent.Content[i-1].Line
+	}
+
+	j, err := find(node, token)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 1 {
+		return set(node, j, token, copyNode(value, line), line)
+	}
+
+	if j == -1 {
+		if token.kind == appendToken || !token.optional {
+			return notFound(token)
+		}
+
+		container := &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map", Line: line}
+		if token.kind == matchToken {
+			container.Content = []*yaml3.Node{
+				{Kind: yaml3.ScalarNode, Tag: "!!str", Value: token.key, Line: line},
+				{Kind: yaml3.ScalarNode, Tag: "!!str", Value: token.value, Line: line},
+			}
+		} else if next := tokens[1].kind; next == indexToken || next == matchToken || next == appendToken {
+			container = &yaml3.Node{Kind: yaml3.SequenceNode, Tag: "!!seq", Line: line}
+		}
+
+		if err := set(node, j, token, container, line); err != nil {
+			return err
+		}
+
+		if j, err = find(node, token); err != nil {
+			return err
+		}
+		if j == -1 {
+			j = len(node.Content) - 1
+		}
+	}
+
+	return replaceAt(node, j, tokens[1:], value)
+}
+
+// set replaces the content at index j of node, or adds it when j is -1.
+func set(node *yaml3.Node, j int, token pathToken, value *yaml3.Node, line int) error {
+	if j != -1 {
+		node.Content[j] = value
+		return nil
+	}
+
+	switch token.kind {
+	case keyToken:
+		key := &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: token.key, Line: line}
+		node.Content = append(node.Content, key, value)
+	case matchToken:
+		if !token.optional {
+			return notFound(token)
+		}
+		node.Content = append(node.Content, value)
+	case appendToken:
+		node.Content = append(node.Content, value)
+	default:
+		return notFound(token)
+	}
+
+	return nil
+}
+
+// removeAt walks tokens from node and deletes the last. A missing optional
+// path is left alone.
+func removeAt(parent *yaml3.Node, i int, tokens []pathToken) error {
+	node := child(parent, i)
+	token := tokens[0]
+
+	if token.kind == appendToken {
+		return fmt.Errorf("Expected an index or key to remove, not '-'")
+	}
+
+	j, err := find(node, token)
+	if err != nil {
+		return err
+	}
+
+	if j == -1 {
+		if token.optional {
+			return nil
+		}
+		return notFound(token)
+	}
+
+	if len(tokens) > 1 {
+		return removeAt(node, j, tokens[1:])
+	}
+
+	if node.Kind == yaml3.MappingNode {
+		node.Content = append(node.Content[:j-1], node.Content[j+1:]...)
+	} else {
+		node.Content = append(node.Content[:j], node.Content[j+1:]...)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package manifest_test
+
+import (
+	. "github.com/odlp/antifreeze/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+var _ = Describe("Ops Files", func() {
+	It("applies replace and remove operations before finding the app", func() {
+		app, err := LoadApplication("../fixtures/manifest.yml", "app-name", "../fixtures/ops/prod.yml")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(app.Env).To(Equal(map[string]interface{}{
+			"ENV_VAR_1": 1800,
+			"ENV_VAR_2": "https://example.com",
+			"ENV_SNOW":  "flake",
+		}))
+		Expect(app.Services).To(Equal([]string{"service-1", "surprise-service"}))
+	})
+
+	It("points added keys at the block they're added to", func() {
+		app, err := LoadApplication("../fixtures/manifest.yml", "app-name", "../fixtures/ops/prod.yml")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(app.Lines.EnvKeys["ENV_VAR_2"]).To(Equal(11))
+		Expect(app.Lines.EnvKeys["ENV_SNOW"]).To(Equal(9))
+		Expect(app.Lines.ServiceNames["surprise-service"]).To(Equal(6))
+	})
+
+	It("creates optional parts of the path which are missing", func() {
+		var root yaml3.Node
+		Expect(yaml3.Unmarshal([]byte("applications:\n- name: app-name\n"), &root)).To(Succeed())
+
+		Expect(ApplyOps(&root, []Op{
+			{Type: OpReplace, Path: "/applications/name=app-name/env?/GREETING", Value: "hello"},
+			{Type: OpReplace, Path: "/applications/name=worker?/instances", Value: 2},
+		})).To(Succeed())
+
+		out, err := yaml3.Marshal(&root)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(out)).To(Equal("applications:\n    - name: app-name\n      env:\n        GREETING: hello\n    - name: worker\n      instances: 2\n"))
+	})
+
+	It("escapes app names in paths", func() {
+		Expect(AppPath("app/1~", "env", "KEY?")).To(Equal("/applications/name=app~11~0/env/KEY?"))
+	})
+
+	It("fails on a path which isn't in the manifest", func() {
+		_, err := LoadApplication("../fixtures/manifest.yml", "app-name", "../fixtures/ops/invalid-ops.yml")
+		Expect(err).To(MatchError("Unable to apply op 1 (replace /applications/name=app-name/routes/0/route) in ops file ../fixtures/ops/invalid-ops.yml: Expected to find a map key 'routes'"))
+	})
+
+	It("fails on an op it can't apply", func() {
+		var root yaml3.Node
+		Expect(yaml3.Unmarshal([]byte("applications: []\n"), &root)).To(Succeed())
+
+		err := ApplyOps(&root, []Op{{Type: "test", Path: "/applications"}})
+		Expect(err).To(MatchError("Unable to apply op 1 (test /applications): Unknown op type 'test', expected replace or remove"))
+	})
+
+	It("requires the ops file to exist", func() {
+		_, err := Load("../fixtures/manifest.yml", "../fixtures/ops/missing.yml")
+		Expect(err).To(MatchError("Unable to read ops file: ../fixtures/ops/missing.yml"))
+	})
+})
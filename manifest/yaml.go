@@ -0,0 +1,60 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// SizeInMB converts a manifest size such as 256M or 1G into megabytes.
+func SizeInMB(size string) (int64, error) {
+	match := sizeUnitPattern.FindStringSubmatch(strings.TrimSpace(size))
+	if match == nil {
+		return 0, fmt.Errorf("Invalid size '%s'", size)
+	}
+
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size '%s'", size)
+	}
+
+	return int64(value * sizeUnitsInMB[strings.ToUpper(match[2][:1])]), nil
+}
+
+// MappingValue returns the value of a key in a YAML mapping, or nil.
+func MappingValue(mapping *yaml3.Node, key string) *yaml3.Node {
+	_, value := MappingEntry(mapping, key)
+	return value
+}
+
+// MappingEntry returns the key and value nodes of a key in a YAML mapping,
+// or nils.
+func MappingEntry(mapping *yaml3.Node, key string) (*yaml3.Node, *yaml3.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], ResolveAlias(mapping.Content[i+1])
+		}
+	}
+	return nil, nil
+}
+
+// ResolveAlias follows an alias to the node it refers to.
+func ResolveAlias(node *yaml3.Node) *yaml3.Node {
+	for node.Kind == yaml3.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+var sizeUnitsInMB = map[string]float64{
+	"B": 1.0 / (1024 * 1024),
+	"K": 1.0 / 1024,
+	"M": 1,
+	"G": 1024,
+	"T": 1024 * 1024,
+}
+
+var sizeUnitPattern = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*(B|K|KB|M|MB|G|GB|T|TB)$`)
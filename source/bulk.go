@@ -0,0 +1,111 @@
+// Package source fetches the state of apps in Cloud Foundry, through the
+// cf CLI's plugin connection or, without the cf CLI, the Cloud Controller
+// API. A session of the calls made can be recorded and replayed.
+package source
+
+import (
+	"sort"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/internal/cc"
+)
+
+// AppState is an app as fetched from Cloud Foundry. When it was fetched in
+// bulk with the rest of its space, it includes the app's v3 resource and
+// processes, which are otherwise fetched as they're needed.
+type AppState struct {
+	Model     plugin_models.GetAppModel
+	Bulk      bool
+	App       cc.App
+	Processes []cc.Process
+}
+
+// FetchSpace fetches every app in a space, with its ENV vars, services and
+// processes, in a handful of paginated requests rather than several for
+// each app. Apps are keyed by name.
+func FetchSpace(client *cc.Client, spaceGUID string) (map[string]AppState, error) {
+	apps, err := client.GetApps(spaceGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	v2Apps, err := client.GetV2Apps(spaceGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	processes, err := client.GetSpaceProcesses(spaceGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var guids []string
+	for _, app := range apps {
+		guids = append(guids, app.GUID)
+	}
+
+	bindings, err := client.GetServiceBindings(guids)
+	if err != nil {
+		return nil, err
+	}
+
+	states := map[string]AppState{}
+	byGUID := map[string]string{}
+
+	for _, app := range apps {
+		byGUID[app.GUID] = app.Name
+		states[app.Name] = AppState{
+			Bulk: true,
+			App:  app,
+			Model: plugin_models.GetAppModel{
+				Guid:      app.GUID,
+				Name:      app.Name,
+				State:     app.State,
+				SpaceGuid: spaceGUID,
+			},
+			Processes: []cc.Process{},
+		}
+	}
+
+	for _, v2App := range v2Apps {
+		name, ok := byGUID[v2App.Metadata.GUID]
+		if !ok {
+			continue
+		}
+
+		state := states[name]
+		state.Model.EnvironmentVars = v2App.Entity.EnvironmentVariables
+		state.Model.InstanceCount = v2App.Entity.Instances
+		state.Model.Memory = v2App.Entity.Memory
+		state.Model.DiskQuota = v2App.Entity.DiskQuota
+		states[name] = state
+	}
+
+	for _, p := range processes {
+		if name, ok := byGUID[p.AppGUID()]; ok {
+			state := states[name]
+			state.Processes = append(state.Processes, p)
+			states[name] = state
+		}
+	}
+
+	for _, b := range bindings {
+		if name, ok := byGUID[b.AppGUID()]; ok {
+			state := states[name]
+			state.Model.Services = append(state.Model.Services, plugin_models.GetApp_ServiceSummary{
+				Guid: b.ServiceInstanceGUID(),
+				Name: b.ServiceInstanceName,
+			})
+			states[name] = state
+		}
+	}
+
+	for name, state := range states {
+		sort.Slice(state.Model.Services, func(i, j int) bool {
+			return state.Model.Services[i].Name < state.Model.Services[j].Name
+		})
+		states[name] = state
+	}
+
+	return states, nil
+}
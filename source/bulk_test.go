@@ -0,0 +1,60 @@
+package source_test
+
+import (
+	"net/http/httptest"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	"github.com/odlp/antifreeze/check"
+	"github.com/odlp/antifreeze/internal/cc"
+	"github.com/odlp/antifreeze/internal/fakecc"
+	"github.com/odlp/antifreeze/manifest"
+	. "github.com/odlp/antifreeze/source"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fetching a space", func() {
+	var space *fakecc.Space
+	var server *httptest.Server
+	var client *cc.Client
+
+	BeforeEach(func() {
+		space = &fakecc.Space{Apps: []fakecc.App{
+			{Name: "app-1", Env: map[string]interface{}{"ENV_VAR_1": float64(1800)}, Services: []string{"service-2", "service-1"}},
+			{Name: "app-2", Env: map[string]interface{}{"ENV_VAR_3": float64(3600)}},
+		}}
+		server = httptest.NewServer(space)
+
+		connection := &pluginfakes.FakeCliConnection{}
+		connection.ApiEndpointReturns(server.URL, nil)
+		connection.AccessTokenReturns("bearer token", nil)
+		client = cc.NewClient(connection)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("fetches each app's ENV vars and services the way GetApp would", func() {
+		states, err := FetchSpace(client, "space-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(states).To(HaveLen(2))
+
+		manifestApp, err := manifest.LoadApplication("../fixtures/multi-manifest.yml", "app-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		expected := plugin_models.GetAppModel{
+			Guid:            "app-1-guid",
+			EnvironmentVars: map[string]interface{}{"ENV_VAR_1": float64(1800)},
+			Services: []plugin_models.GetApp_ServiceSummary{
+				{Guid: "service-1-guid", Name: "service-1"},
+				{Guid: "service-2-guid", Name: "service-2"},
+			},
+		}
+
+		Expect(check.CompareApp("manifest.yml", manifestApp, states["app-1"].Model)).To(Equal(check.CompareApp("manifest.yml", manifestApp, expected)))
+		Expect(states["app-1"].Model.Services).To(Equal(expected.Services))
+		Expect(states["app-1"].Processes).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,412 @@
+package source
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/internal/secrets"
+	"github.com/odlp/antifreeze/manifest"
+)
+
+// Session is a recording of the plugin RPC calls and Cloud Controller
+// requests made during a check, which can be replayed without access to
+// Cloud Foundry to reproduce its findings.
+type Session struct {
+	Calls    []SessionCall    `json:"calls"`
+	Requests []SessionRequest `json:"requests"`
+}
+
+// SessionCall is one plugin RPC call and its result.
+type SessionCall struct {
+	Method string          `json:"method"`
+	Args   []string        `json:"args,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// SessionRequest is one Cloud Controller request, identified by its path
+// and query so it can be replayed against any endpoint.
+type SessionRequest struct {
+	Path   string          `json:"path"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// LoadSession reads a session recorded with --record.
+func LoadSession(sessionPath string) (Session, error) {
+	b, err := ioutil.ReadFile(sessionPath)
+	if err != nil {
+		return Session{}, fmt.Errorf("Unable to read session file: %s", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(b, &session); err != nil {
+		return Session{}, fmt.Errorf("Unable to parse session file: %s", err)
+	}
+
+	return session, nil
+}
+
+// Recorder is a plugin connection which records the calls made through it,
+// and the Cloud Controller requests made through its Transport, with
+// credentials redacted.
+type Recorder struct {
+	plugin.CliConnection
+
+	mu      sync.Mutex
+	session Session
+}
+
+func NewRecorder(cliConnection plugin.CliConnection) *Recorder {
+	return &Recorder{CliConnection: cliConnection}
+}
+
+// Save writes the session recorded so far.
+func (r *Recorder) Save(sessionPath string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.MarshalIndent(r.session, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(sessionPath, append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("Unable to write session file: %s", err)
+	}
+	return nil
+}
+
+func (r *Recorder) record(method string, args []string, result interface{}, err error) {
+	call := SessionCall{Method: method, Args: args}
+
+	if err != nil {
+		call.Error = err.Error()
+	} else {
+		call.Result, _ = json.Marshal(result)
+	}
+
+	r.mu.Lock()
+	r.session.Calls = append(r.session.Calls, call)
+	r.mu.Unlock()
+}
+
+func (r *Recorder) GetApp(appName string) (plugin_models.GetAppModel, error) {
+	app, err := r.CliConnection.GetApp(appName)
+
+	redacted := app
+	redacted.EnvironmentVars = redactEnv(app.EnvironmentVars)
+	r.record("GetApp", []string{appName}, redacted, err)
+
+	return app, err
+}
+
+func (r *Recorder) GetCurrentSpace() (plugin_models.Space, error) {
+	space, err := r.CliConnection.GetCurrentSpace()
+	r.record("GetCurrentSpace", nil, space, err)
+	return space, err
+}
+
+func (r *Recorder) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	output, err := r.CliConnection.CliCommandWithoutTerminalOutput(args...)
+	r.record("CliCommandWithoutTerminalOutput", args, redactOutput(output), err)
+	return output, err
+}
+
+func (r *Recorder) ApiEndpoint() (string, error) {
+	endpoint, err := r.CliConnection.ApiEndpoint()
+	r.record("ApiEndpoint", nil, endpoint, err)
+	return endpoint, err
+}
+
+func (r *Recorder) AccessToken() (string, error) {
+	token, err := r.CliConnection.AccessToken()
+	r.record("AccessToken", nil, "bearer "+redacted, err)
+	return token, err
+}
+
+func (r *Recorder) IsSSLDisabled() (bool, error) {
+	disabled, err := r.CliConnection.IsSSLDisabled()
+	r.record("IsSSLDisabled", nil, disabled, err)
+	return disabled, err
+}
+
+// Transport records the requests made through it. It skips SSL validation
+// when the target was set with --skip-ssl-validation, as the Cloud
+// Controller client can't configure a transport it doesn't recognise.
+func (r *Recorder) Transport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if disabled, err := r.CliConnection.IsSSLDisabled(); err == nil && disabled {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return recordingTransport{recorder: r, transport: transport}
+}
+
+type recordingTransport struct {
+	recorder  *Recorder
+	transport http.RoundTripper
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	request := SessionRequest{Path: req.URL.RequestURI(), Status: resp.StatusCode, Body: redactBody(body)}
+
+	t.recorder.mu.Lock()
+	t.recorder.session.Requests = append(t.recorder.session.Requests, request)
+	t.recorder.mu.Unlock()
+
+	return resp, nil
+}
+
+// Replayer is a plugin connection, and a transport for the Cloud Controller
+// client, which serves the responses of a recorded session. Repeated calls
+// get the recorded responses in turn, then the last of them again, as apps
+// checked at once may not make their calls in the recorded order.
+type Replayer struct {
+	// plugin.CliConnection is left nil: only the calls which are recorded
+	// can be replayed.
+	plugin.CliConnection
+
+	mu       sync.Mutex
+	calls    map[string][]SessionCall
+	requests map[string][]SessionRequest
+}
+
+func NewReplayer(session Session) *Replayer {
+	r := &Replayer{
+		calls:    map[string][]SessionCall{},
+		requests: map[string][]SessionRequest{},
+	}
+
+	for _, call := range session.Calls {
+		key := callKey(call.Method, call.Args)
+		r.calls[key] = append(r.calls[key], call)
+	}
+
+	for _, request := range session.Requests {
+		r.requests[request.Path] = append(r.requests[request.Path], request)
+	}
+
+	return r
+}
+
+func callKey(method string, args []string) string {
+	return method + " " + strings.Join(args, " ")
+}
+
+func (r *Replayer) replay(method string, args []string, result interface{}) error {
+	r.mu.Lock()
+	key := callKey(method, args)
+	calls := r.calls[key]
+	if len(calls) > 1 {
+		r.calls[key] = calls[1:]
+	}
+	r.mu.Unlock()
+
+	if len(calls) == 0 {
+		return fmt.Errorf("No recorded response for %s in session file", strings.TrimSpace(key))
+	}
+
+	call := calls[0]
+	if call.Error != "" {
+		return fmt.Errorf("%s", call.Error)
+	}
+	return json.Unmarshal(call.Result, result)
+}
+
+func (r *Replayer) GetApp(appName string) (app plugin_models.GetAppModel, err error) {
+	err = r.replay("GetApp", []string{appName}, &app)
+	return app, err
+}
+
+func (r *Replayer) GetCurrentSpace() (space plugin_models.Space, err error) {
+	err = r.replay("GetCurrentSpace", nil, &space)
+	return space, err
+}
+
+func (r *Replayer) CliCommandWithoutTerminalOutput(args ...string) (output []string, err error) {
+	err = r.replay("CliCommandWithoutTerminalOutput", args, &output)
+	return output, err
+}
+
+func (r *Replayer) ApiEndpoint() (endpoint string, err error) {
+	err = r.replay("ApiEndpoint", nil, &endpoint)
+	return endpoint, err
+}
+
+func (r *Replayer) AccessToken() (token string, err error) {
+	err = r.replay("AccessToken", nil, &token)
+	return token, err
+}
+
+func (r *Replayer) IsSSLDisabled() (disabled bool, err error) {
+	err = r.replay("IsSSLDisabled", nil, &disabled)
+	return disabled, err
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.RequestURI()
+
+	r.mu.Lock()
+	requests := r.requests[path]
+	if len(requests) > 1 {
+		r.requests[path] = requests[1:]
+	}
+	r.mu.Unlock()
+
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("No recorded response for %s in session file", path)
+	}
+
+	return &http.Response{
+		StatusCode: requests[0].Status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(requests[0].Body)),
+		Request:    req,
+	}, nil
+}
+
+// redactBody redacts credentials in a JSON response body. Bodies which
+// aren't JSON aren't expected from the Cloud Controller, and are recorded
+// as null rather than risk recording a secret.
+func redactBody(body []byte) json.RawMessage {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return json.RawMessage("null")
+	}
+
+	b, _ := json.Marshal(redactJSON("", v))
+	return b
+}
+
+// redactOutput redacts credentials in the output of `cf curl`, which is
+// JSON spread over several lines. Like bodies, output which isn't JSON is
+// recorded as null rather than risk recording a secret.
+func redactOutput(output []string) []string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(strings.Join(output, "\n")), &v); err != nil {
+		return []string{"null"}
+	}
+
+	b, _ := json.Marshal(redactJSON("", v))
+	return []string{string(b)}
+}
+
+// redactJSON replaces the credentials in a decoded JSON value: everything
+// beneath a `credentials` key, every ENV value, the values of keys named
+// like secrets, and strings which look like a secret. Links and GUIDs are
+// kept, as a replay needs them.
+func redactJSON(key string, v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if value == nil {
+			return value
+		}
+		redactedMap := map[string]interface{}{}
+		for k, child := range value {
+			if k == "credentials" {
+				redactedMap[k] = redactAll(child)
+			} else if env, ok := child.(map[string]interface{}); ok && envKeys[k] {
+				redactedMap[k] = redactEnv(env)
+			} else {
+				redactedMap[k] = redactJSON(k, child)
+			}
+		}
+		return redactedMap
+	case []interface{}:
+		redactedList := make([]interface{}, len(value))
+		for i, child := range value {
+			redactedList[i] = redactJSON(key, child)
+		}
+		return redactedList
+	case nil:
+		return nil
+	}
+
+	if secrets.IsSecretName(key) {
+		return redacted
+	}
+
+	if s, ok := v.(string); ok && !isReplayKey(key) && looksLikeSecret(s) {
+		return redacted
+	}
+
+	return v
+}
+
+func redactAll(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		redactedMap := map[string]interface{}{}
+		for k, child := range value {
+			redactedMap[k] = redactAll(child)
+		}
+		return redactedMap
+	case []interface{}:
+		redactedList := make([]interface{}, len(value))
+		for i, child := range value {
+			redactedList[i] = redactAll(child)
+		}
+		return redactedList
+	case nil:
+		return nil
+	}
+	return redacted
+}
+
+// envKeys hold ENV vars in Cloud Controller responses: an app's own, in v3
+// and v2, its groups' and the env groups themselves.
+var envKeys = map[string]bool{
+	"environment_variables": true,
+	"environment_json":      true,
+	"running_env_json":      true,
+	"staging_env_json":      true,
+	"var":                   true,
+}
+
+// redactEnv replaces every ENV value with a placeholder holding its digest.
+// Replays only need the keys, and whether each value matches the
+// manifest's, which the digest still tells.
+func redactEnv(env map[string]interface{}) map[string]interface{} {
+	if env == nil {
+		return nil
+	}
+
+	redactedEnv := map[string]interface{}{}
+	for k, v := range env {
+		redactedEnv[k] = secrets.Redact(manifest.EnvValueString(v))
+	}
+	return redactedEnv
+}
+
+// isReplayKey reports whether a key holds a link or GUID, which a replay
+// needs to follow pagination and relationships.
+func isReplayKey(key string) bool {
+	return key == "href" || key == "next_url" || key == "prev_url" || key == "guid" || strings.HasSuffix(key, "_guid")
+}
+
+func looksLikeSecret(value string) bool {
+	_, _, ok := secrets.Detect(value)
+	return ok
+}
+
+const redacted = "[REDACTED]"
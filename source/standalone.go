@@ -0,0 +1,433 @@
+package source
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/odlp/antifreeze/internal/cc"
+)
+
+// CFConfig is the part of the cf CLI's config.json needed to talk to the
+// Cloud Controller without the cf CLI.
+type CFConfig struct {
+	Target               string
+	AccessToken          string
+	RefreshToken         string
+	UaaEndpoint          string
+	SSLDisabled          bool
+	UAAOAuthClient       string
+	UAAOAuthClientSecret string
+	SpaceFields          struct {
+		GUID string
+		Name string
+	}
+}
+
+// LoadCFConfig reads the config written by `cf login`, from $CF_HOME/.cf or
+// ~/.cf, then applies any of CF_API, CF_ACCESS_TOKEN, CF_REFRESH_TOKEN,
+// CF_SPACE_GUID and CF_SPACE set in the environment. A missing config file
+// is fine when the environment covers it.
+func LoadCFConfig(getenv func(string) string) (CFConfig, error) {
+	home := getenv("CF_HOME")
+	if home == "" {
+		home = getenv("HOME")
+	}
+
+	var config CFConfig
+	configPath := filepath.Join(home, ".cf", "config.json")
+
+	b, err := ioutil.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return CFConfig{}, fmt.Errorf("Unable to read cf config: %s", err)
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(b, &config); err != nil {
+			return CFConfig{}, fmt.Errorf("Unable to parse cf config %s: %s", configPath, err)
+		}
+	}
+
+	overrides := []struct {
+		name  string
+		field *string
+	}{
+		{"CF_API", &config.Target},
+		{"CF_ACCESS_TOKEN", &config.AccessToken},
+		{"CF_REFRESH_TOKEN", &config.RefreshToken},
+		{"CF_SPACE_GUID", &config.SpaceFields.GUID},
+		{"CF_SPACE", &config.SpaceFields.Name},
+	}
+
+	for _, o := range overrides {
+		if value := getenv(o.name); value != "" {
+			*o.field = value
+		}
+	}
+
+	if config.Target == "" {
+		return CFConfig{}, fmt.Errorf("No API endpoint set, run `cf login` or set CF_API")
+	}
+
+	if config.AccessToken == "" && config.RefreshToken == "" {
+		return CFConfig{}, fmt.Errorf("Not logged in, run `cf login` or set CF_ACCESS_TOKEN or CF_REFRESH_TOKEN")
+	}
+
+	if config.UAAOAuthClient == "" {
+		config.UAAOAuthClient = "cf"
+	}
+
+	return config, nil
+}
+
+// StandaloneConnection stands in for the cf CLI when antifreeze runs on its
+// own, answering the calls the checks make from the cf config and the
+// Cloud Controller API.
+type StandaloneConnection struct {
+	config     CFConfig
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+func NewStandaloneConnection(config CFConfig) *StandaloneConnection {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.SSLDisabled {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &StandaloneConnection{
+		config:     config,
+		httpClient: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		token:      config.AccessToken,
+	}
+}
+
+func (c *StandaloneConnection) ApiEndpoint() (string, error) {
+	return strings.TrimSuffix(c.config.Target, "/"), nil
+}
+
+func (c *StandaloneConnection) IsSSLDisabled() (bool, error) {
+	return c.config.SSLDisabled, nil
+}
+
+func (c *StandaloneConnection) GetCurrentSpace() (plugin_models.Space, error) {
+	if c.config.SpaceFields.GUID == "" {
+		return plugin_models.Space{}, fmt.Errorf("No space targeted, run `cf target -s SPACE` or set CF_SPACE_GUID")
+	}
+
+	return plugin_models.Space{SpaceFields: plugin_models.SpaceFields{
+		Guid: c.config.SpaceFields.GUID,
+		Name: c.config.SpaceFields.Name,
+	}}, nil
+}
+
+// AccessToken returns the token from the config until it's about to
+// expire, then one refreshed with UAA, like the cf CLI does.
+func (c *StandaloneConnection) AccessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && !tokenExpiring(c.token, time.Now()) {
+		return c.token, nil
+	}
+
+	if c.config.RefreshToken == "" {
+		if c.token != "" {
+			return c.token, nil
+		}
+		return "", fmt.Errorf("No refresh token to get a new access token with, run `cf login`")
+	}
+
+	token, err := c.refreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	c.token = token
+	return token, nil
+}
+
+func (c *StandaloneConnection) refreshToken() (string, error) {
+	uaa, err := c.uaaEndpoint()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", c.config.RefreshToken)
+
+	req, err := http.NewRequest("POST", uaa+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.config.UAAOAuthClient, c.config.UAAOAuthClientSecret)
+
+	var response struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.doJSON(req, &response); err != nil {
+		return "", fmt.Errorf("Unable to refresh access token: %s", err)
+	}
+
+	if response.RefreshToken != "" {
+		c.config.RefreshToken = response.RefreshToken
+	}
+
+	tokenType := response.TokenType
+	if tokenType == "" {
+		tokenType = "bearer"
+	}
+	return tokenType + " " + response.AccessToken, nil
+}
+
+// uaaEndpoint is the UAA endpoint from the config, or else as advertised
+// by the Cloud Controller.
+func (c *StandaloneConnection) uaaEndpoint() (string, error) {
+	if c.config.UaaEndpoint != "" {
+		return strings.TrimSuffix(c.config.UaaEndpoint, "/"), nil
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(c.config.Target, "/")+"/", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var root struct {
+		Links struct {
+			UAA *struct {
+				Href string `json:"href"`
+			} `json:"uaa"`
+		} `json:"links"`
+	}
+
+	if err := c.doJSON(req, &root); err != nil {
+		return "", fmt.Errorf("Unable to find UAA endpoint: %s", err)
+	}
+
+	if root.Links.UAA == nil || root.Links.UAA.Href == "" {
+		return "", fmt.Errorf("Unable to find UAA endpoint: not advertised by %s", c.config.Target)
+	}
+
+	c.config.UaaEndpoint = root.Links.UAA.Href
+	return strings.TrimSuffix(root.Links.UAA.Href, "/"), nil
+}
+
+func (c *StandaloneConnection) doJSON(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s responded with status %d", req.URL.Host, resp.StatusCode)
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// tokenExpiring reports whether a JWT expires within the next minute. A
+// token which can't be read is assumed to still be valid.
+func tokenExpiring(token string, now time.Time) bool {
+	parts := strings.Split(strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(token, "bearer "), "Bearer ")), ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return false
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return false
+	}
+
+	return time.Unix(claims.Exp, 0).Before(now.Add(time.Minute))
+}
+
+// GetApp looks the app up in the targeted space, with its ENV vars,
+// services and web process, as the cf CLI would.
+func (c *StandaloneConnection) GetApp(appName string) (plugin_models.GetAppModel, error) {
+	space, err := c.GetCurrentSpace()
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+
+	client := cc.NewClient(c)
+
+	apps, err := client.GetApps(space.Guid, appName)
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+
+	if len(apps) == 0 {
+		return plugin_models.GetAppModel{}, fmt.Errorf("App %s not found", appName)
+	}
+
+	model := plugin_models.GetAppModel{
+		Guid:      apps[0].GUID,
+		Name:      apps[0].Name,
+		State:     apps[0].State,
+		SpaceGuid: space.Guid,
+	}
+
+	env, err := client.GetAppEnvironment(model.Guid)
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+	model.EnvironmentVars = env.EnvironmentVariables
+
+	processes, err := client.GetProcesses(model.Guid)
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+
+	for _, p := range processes {
+		if p.Type == "web" {
+			model.InstanceCount = p.Instances
+			model.Memory = p.MemoryInMB
+			model.DiskQuota = p.DiskInMB
+		}
+	}
+
+	bindings, err := client.GetServiceBindings([]string{model.Guid})
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+
+	for _, b := range bindings {
+		model.Services = append(model.Services, plugin_models.GetApp_ServiceSummary{
+			Guid: b.ServiceInstanceGUID(),
+			Name: b.ServiceInstanceName,
+		})
+	}
+
+	sort.Slice(model.Services, func(i, j int) bool {
+		return model.Services[i].Name < model.Services[j].Name
+	})
+
+	return model, nil
+}
+
+// The cf CLI commands and the calls the checks don't make aren't
+// supported without the cf CLI.
+
+func (c *StandaloneConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	return nil, unsupported("'cf " + strings.Join(args, " ") + "'")
+}
+
+func (c *StandaloneConnection) CliCommand(args ...string) ([]string, error) {
+	return nil, unsupported("'cf " + strings.Join(args, " ") + "'")
+}
+
+func (c *StandaloneConnection) GetCurrentOrg() (plugin_models.Organization, error) {
+	return plugin_models.Organization{}, unsupported("GetCurrentOrg")
+}
+
+func (c *StandaloneConnection) Username() (string, error) {
+	return "", unsupported("Username")
+}
+
+func (c *StandaloneConnection) UserGuid() (string, error) {
+	return "", unsupported("UserGuid")
+}
+
+func (c *StandaloneConnection) UserEmail() (string, error) {
+	return "", unsupported("UserEmail")
+}
+
+func (c *StandaloneConnection) IsLoggedIn() (bool, error) {
+	return false, unsupported("IsLoggedIn")
+}
+
+func (c *StandaloneConnection) HasOrganization() (bool, error) {
+	return false, unsupported("HasOrganization")
+}
+
+func (c *StandaloneConnection) HasSpace() (bool, error) {
+	return false, unsupported("HasSpace")
+}
+
+func (c *StandaloneConnection) ApiVersion() (string, error) {
+	return "", unsupported("ApiVersion")
+}
+
+func (c *StandaloneConnection) HasAPIEndpoint() (bool, error) {
+	return false, unsupported("HasAPIEndpoint")
+}
+
+func (c *StandaloneConnection) LoggregatorEndpoint() (string, error) {
+	return "", unsupported("LoggregatorEndpoint")
+}
+
+func (c *StandaloneConnection) DopplerEndpoint() (string, error) {
+	return "", unsupported("DopplerEndpoint")
+}
+
+func (c *StandaloneConnection) GetApps() ([]plugin_models.GetAppsModel, error) {
+	return nil, unsupported("GetApps")
+}
+
+func (c *StandaloneConnection) GetOrgs() ([]plugin_models.GetOrgs_Model, error) {
+	return nil, unsupported("GetOrgs")
+}
+
+func (c *StandaloneConnection) GetSpaces() ([]plugin_models.GetSpaces_Model, error) {
+	return nil, unsupported("GetSpaces")
+}
+
+func (c *StandaloneConnection) GetOrgUsers(string, ...string) ([]plugin_models.GetOrgUsers_Model, error) {
+	return nil, unsupported("GetOrgUsers")
+}
+
+func (c *StandaloneConnection) GetSpaceUsers(string, string) ([]plugin_models.GetSpaceUsers_Model, error) {
+	return nil, unsupported("GetSpaceUsers")
+}
+
+func (c *StandaloneConnection) GetServices() ([]plugin_models.GetServices_Model, error) {
+	return nil, unsupported("GetServices")
+}
+
+func (c *StandaloneConnection) GetService(string) (plugin_models.GetService_Model, error) {
+	return plugin_models.GetService_Model{}, unsupported("GetService")
+}
+
+func (c *StandaloneConnection) GetOrg(string) (plugin_models.GetOrg_Model, error) {
+	return plugin_models.GetOrg_Model{}, unsupported("GetOrg")
+}
+
+func (c *StandaloneConnection) GetSpace(string) (plugin_models.GetSpace_Model, error) {
+	return plugin_models.GetSpace_Model{}, unsupported("GetSpace")
+}
+
+func unsupported(call string) error {
+	return fmt.Errorf("%s is not supported in standalone mode", call)
+}
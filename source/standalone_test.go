@@ -0,0 +1,196 @@
+package source_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	. "github.com/odlp/antifreeze/source"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func jwtExpiringAt(exp time.Time) string {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp": %d}`, exp.Unix())))
+	return "bearer eyJhbGciOiJSUzI1NiJ9." + claims + ".signature"
+}
+
+var _ = Describe("Running without the cf CLI", func() {
+	Describe("LoadCFConfig", func() {
+		var cfHome string
+		var env map[string]string
+
+		BeforeEach(func() {
+			var err error
+			cfHome, err = ioutil.TempDir("", "antifreeze-cf-home")
+			Expect(err).ToNot(HaveOccurred())
+			env = map[string]string{"CF_HOME": cfHome}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(cfHome)
+		})
+
+		getenv := func(name string) string {
+			return env[name]
+		}
+
+		It("reads the target and tokens written by cf login", func() {
+			Expect(os.MkdirAll(filepath.Join(cfHome, ".cf"), 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(cfHome, ".cf", "config.json"), []byte(`{
+				"Target": "https://api.example.com",
+				"AccessToken": "bearer access",
+				"RefreshToken": "refresh",
+				"UaaEndpoint": "https://uaa.example.com",
+				"SSLDisabled": true,
+				"SpaceFields": {"GUID": "space-guid", "Name": "space-name"}
+			}`), 0600)).To(Succeed())
+
+			config, err := LoadCFConfig(getenv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Target).To(Equal("https://api.example.com"))
+			Expect(config.RefreshToken).To(Equal("refresh"))
+			Expect(config.SSLDisabled).To(BeTrue())
+			Expect(config.SpaceFields.GUID).To(Equal("space-guid"))
+			Expect(config.UAAOAuthClient).To(Equal("cf"))
+		})
+
+		It("takes settings from the environment", func() {
+			env["CF_API"] = "https://api.example.com"
+			env["CF_ACCESS_TOKEN"] = "bearer access"
+			env["CF_SPACE_GUID"] = "space-guid"
+
+			config, err := LoadCFConfig(getenv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Target).To(Equal("https://api.example.com"))
+			Expect(config.AccessToken).To(Equal("bearer access"))
+			Expect(config.SpaceFields.GUID).To(Equal("space-guid"))
+		})
+
+		It("requires a target and a token", func() {
+			_, err := LoadCFConfig(getenv)
+			Expect(err).To(MatchError("No API endpoint set, run `cf login` or set CF_API"))
+
+			env["CF_API"] = "https://api.example.com"
+			_, err = LoadCFConfig(getenv)
+			Expect(err).To(MatchError("Not logged in, run `cf login` or set CF_ACCESS_TOKEN or CF_REFRESH_TOKEN"))
+		})
+	})
+
+	Describe("StandaloneConnection", func() {
+		var server *httptest.Server
+		var mux *http.ServeMux
+		var config CFConfig
+		var refreshes int
+
+		BeforeEach(func() {
+			refreshes = 0
+			mux = http.NewServeMux()
+			server = httptest.NewServer(mux)
+
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"links": {"uaa": {"href": "%s/uaa"}}}`, server.URL)
+			})
+			mux.HandleFunc("/uaa/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+				refreshes++
+				user, password, _ := r.BasicAuth()
+				Expect(user).To(Equal("cf"))
+				Expect(password).To(BeEmpty())
+				Expect(r.FormValue("grant_type")).To(Equal("refresh_token"))
+				Expect(r.FormValue("refresh_token")).To(Equal("refresh"))
+				fmt.Fprint(w, `{"access_token": "fresh", "token_type": "bearer", "refresh_token": "refresh"}`)
+			})
+
+			config = CFConfig{Target: server.URL, RefreshToken: "refresh", UAAOAuthClient: "cf"}
+			config.SpaceFields.GUID = "space-guid"
+			config.SpaceFields.Name = "space-name"
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("uses the access token until it's about to expire", func() {
+			config.AccessToken = jwtExpiringAt(time.Now().Add(time.Hour))
+
+			token, err := NewStandaloneConnection(config).AccessToken()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(token).To(Equal(config.AccessToken))
+			Expect(refreshes).To(Equal(0))
+		})
+
+		It("refreshes an expired access token with UAA", func() {
+			config.AccessToken = jwtExpiringAt(time.Now().Add(-time.Hour))
+			connection := NewStandaloneConnection(config)
+
+			token, err := connection.AccessToken()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(token).To(Equal("bearer fresh"))
+
+			token, err = connection.AccessToken()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(token).To(Equal("bearer fresh"))
+			Expect(refreshes).To(Equal(1))
+		})
+
+		It("looks up an app in the targeted space the way the cf CLI does", func() {
+			mux.HandleFunc("/v3/apps", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Authorization")).To(Equal("bearer fresh"))
+				Expect(r.URL.Query().Get("names")).To(Equal("my-app"))
+				Expect(r.URL.Query().Get("space_guids")).To(Equal("space-guid"))
+				fmt.Fprint(w, `{"pagination": {}, "resources": [{"guid": "app-guid", "name": "my-app", "state": "STARTED"}]}`)
+			})
+			mux.HandleFunc("/v3/apps/app-guid/env", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"environment_variables": {"ENV_VAR": "value"}}`)
+			})
+			mux.HandleFunc("/v3/apps/app-guid/processes", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"pagination": {}, "resources": [{"type": "web", "instances": 2, "memory_in_mb": 256, "disk_in_mb": 1024}, {"type": "worker", "instances": 1}]}`)
+			})
+			mux.HandleFunc("/v3/service_credential_bindings", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Query().Get("app_guids")).To(Equal("app-guid"))
+				fmt.Fprint(w, `{
+					"pagination": {},
+					"resources": [{"relationships": {"app": {"data": {"guid": "app-guid"}}, "service_instance": {"data": {"guid": "si-guid"}}}}],
+					"included": {"service_instances": [{"guid": "si-guid", "name": "postgres-db"}]}
+				}`)
+			})
+
+			app, err := NewStandaloneConnection(config).GetApp("my-app")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(app).To(Equal(plugin_models.GetAppModel{
+				Guid:            "app-guid",
+				Name:            "my-app",
+				State:           "STARTED",
+				SpaceGuid:       "space-guid",
+				EnvironmentVars: map[string]interface{}{"ENV_VAR": "value"},
+				InstanceCount:   2,
+				Memory:          256,
+				DiskQuota:       1024,
+				Services:        []plugin_models.GetApp_ServiceSummary{{Guid: "si-guid", Name: "postgres-db"}},
+			}))
+		})
+
+		It("reports an app which isn't in the targeted space", func() {
+			mux.HandleFunc("/v3/apps", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"pagination": {}, "resources": []}`)
+			})
+
+			_, err := NewStandaloneConnection(config).GetApp("my-app")
+			Expect(err).To(MatchError("App my-app not found"))
+		})
+
+		It("doesn't run cf CLI commands", func() {
+			_, err := NewStandaloneConnection(config).CliCommandWithoutTerminalOutput("curl", "/v3/audit_events")
+			Expect(err).To(MatchError("'cf curl /v3/audit_events' is not supported in standalone mode"))
+
+			_, err = NewStandaloneConnection(config).GetCurrentOrg()
+			Expect(err).To(MatchError("GetCurrentOrg is not supported in standalone mode"))
+		})
+	})
+})